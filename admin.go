@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// newAdminHandler returns the handler for the -pprof admin server: the
+// net/http/pprof profiles under /debug/pprof/, plus /healthz and /metrics so
+// the admin port can be monitored on its own. It carries no authentication
+// and must not be exposed publicly.
+func newAdminHandler(s *Server) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.Handle("/metrics", metricsHandler())
+	return mux
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// withAPIKey rejects requests that do not carry "Authorization: Bearer <key>".
+// The /healthz and /readyz probes are always let through, since orchestrators
+// cannot send the key and they reveal nothing about the archives. With
+// allowSigned, GET /webtoon requests carrying a signed URL's token are
+// let through for handleWebtoon to verify instead; uploads always need the
+// key.
+func withAPIKey(key string, allowSigned bool, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if allowSigned && r.Method == http.MethodGet && r.URL.Path == "/webtoon" && isSignedRequest(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(key)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
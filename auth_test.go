@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAPIKey(t *testing.T) {
+	s, _ := newTestServer(t, WithAPIKey("secret"))
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer wrong", http.StatusUnauthorized},
+		{"not a bearer token", "Basic c2VjcmV0", http.StatusUnauthorized},
+		{"valid token", "Bearer secret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.authorization != "" {
+				header.Set("Authorization", tt.authorization)
+			}
+			rec := serve(s, http.MethodGet, "/webtoon?file=ch1.cbz", header)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			challenge := rec.Header().Get("WWW-Authenticate")
+			if tt.want == http.StatusUnauthorized && challenge != "Bearer" {
+				t.Errorf("WWW-Authenticate = %q, want Bearer", challenge)
+			}
+			if tt.want == http.StatusOK && challenge != "" {
+				t.Errorf("WWW-Authenticate = %q on a successful response", challenge)
+			}
+		})
+	}
+}
+
+func TestAPIKeyExemptsProbes(t *testing.T) {
+	s, _ := newTestServer(t, WithAPIKey("secret"))
+
+	for _, path := range []string{"/healthz", "/readyz"} {
+		if rec := serve(s, http.MethodGet, path, nil); rec.Code != http.StatusOK {
+			t.Errorf("GET %s without a key: status = %d, want 200", path, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alexander-bruun/go-cbz-to-png/cbz"
+)
+
+// stripCacheKey identifies a cached strip. StripOptions holds a slice and a
+// callback, which make it unusable as a map key, so the options are stored
+// in the form stripOptionsKey encodes them.
+type stripCacheKey struct {
+	path string
+	opts string
+}
+
+// newStripCacheKey returns the key of the strip for path built with opts.
+func newStripCacheKey(path string, opts cbz.StripOptions) stripCacheKey {
+	return stripCacheKey{path: path, opts: stripOptionsKey(opts)}
+}
+
+// stripOptionsKey encodes every option that affects the pixels of a strip,
+// one field at a time, so equal strips get equal keys. WorkerCount,
+// DiskThreshold, the callbacks and the input and output limits only change
+// how or whether a strip is built and are left out. Colors are compared by
+// value, whatever their type.
+func stripOptionsKey(opts cbz.StripOptions) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "scale=%t horizontal=%t grid=%d gap=%d gapcolor=%s background=%s rtl=%t",
+		opts.ScaleToWidth, opts.Horizontal, opts.GridColumns, opts.GapHeight,
+		colorKey(opts.GapColor), colorKey(opts.BackgroundColor), opts.RightToLeft)
+	if opts.SelectRange {
+		fmt.Fprintf(&b, " range=%d-%d", opts.StartPage, opts.EndPage)
+	}
+	fmt.Fprintf(&b, " sort=%q desc=%t skip=%v rotate=%t crop=%d split=%t",
+		opts.SortField, opts.SortDescending, opts.SkipPages, opts.AutoRotate, opts.CropMargin, opts.SplitSpreads)
+	if opts.SkipBlankPages {
+		fmt.Fprintf(&b, " blank=%g", opts.BlankThreshold)
+	}
+	fmt.Fprintf(&b, " gray=%t invert=%t brightness=%g contrast=%g watermark=%q opacity=%g max=%dx%d",
+		opts.Grayscale, opts.Invert, opts.Brightness, opts.Contrast, opts.Watermark, opts.WatermarkOpacity, opts.MaxWidth, opts.MaxHeight)
+	return b.String()
+}
+
+// colorKey encodes c as its 16-bit premultiplied RGBA value, or "none" when
+// c is nil and the default applies.
+func colorKey(c color.Color) string {
+	if c == nil {
+		return "none"
+	}
+	r, g, b, a := c.RGBA()
+	return fmt.Sprintf("%04x%04x%04x%04x", r, g, b, a)
+}
+
+type stripCacheEntry struct {
+	key    stripCacheKey
+	img    image.Image
+	result cbz.StripResult
+	mtime  time.Time
+	size   int64
+}
+
+// stripCache is an LRU cache of composed strips bounded by the total number
+// of pixel bytes it holds.
+type stripCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	curBytes int64
+	order    *list.List
+	entries  map[stripCacheKey]*list.Element
+}
+
+func newStripCache(maxBytes int64) *stripCache {
+	return &stripCache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[stripCacheKey]*list.Element),
+	}
+}
+
+// Get returns the cached strip for key if it was built from a file with the
+// given mtime. Stale entries are evicted.
+func (c *stripCache) Get(key stripCacheKey, mtime time.Time) (image.Image, cbz.StripResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, cbz.StripResult{}, false
+	}
+
+	entry := elem.Value.(*stripCacheEntry)
+	if !entry.mtime.Equal(mtime) {
+		c.remove(elem)
+		return nil, cbz.StripResult{}, false
+	}
+
+	c.order.MoveToFront(elem)
+	return entry.img, entry.result, true
+}
+
+// Add stores img and its result under key, evicting the least recently used
+// strips until the cache fits within its byte budget. Strips larger than the
+// whole budget are not cached.
+func (c *stripCache) Add(key stripCacheKey, mtime time.Time, img image.Image, result cbz.StripResult) {
+	size := imageBytes(img)
+	if size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+
+	for c.curBytes+size > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+
+	entry := &stripCacheEntry{key: key, img: img, result: result, mtime: mtime, size: size}
+	c.entries[key] = c.order.PushFront(entry)
+	c.curBytes += size
+}
+
+// RemovePath evicts every strip built from the archive at path, whatever
+// its options, and returns how many were evicted.
+func (c *stripCache) RemovePath(path string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	evicted := 0
+	for key, elem := range c.entries {
+		if key.path == path {
+			c.remove(elem)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+func (c *stripCache) remove(elem *list.Element) {
+	entry := c.order.Remove(elem).(*stripCacheEntry)
+	delete(c.entries, entry.key)
+	c.curBytes -= entry.size
+}
+
+// imageBytes estimates the memory held by img's pixel data.
+func imageBytes(img image.Image) int64 {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return int64(len(rgba.Pix))
+	}
+	bounds := img.Bounds()
+	return int64(bounds.Dx()) * int64(bounds.Dy()) * 4
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+
+	"github.com/alexander-bruun/go-cbz-to-png/cbz"
+)
+
+func TestStripOptionsKey(t *testing.T) {
+	changes := map[string]func(*cbz.StripOptions){
+		"ScaleToWidth":     func(o *cbz.StripOptions) { o.ScaleToWidth = true },
+		"Horizontal":       func(o *cbz.StripOptions) { o.Horizontal = true },
+		"GridColumns":      func(o *cbz.StripOptions) { o.GridColumns = 3 },
+		"GapHeight":        func(o *cbz.StripOptions) { o.GapHeight = 10 },
+		"GapColor":         func(o *cbz.StripOptions) { o.GapColor = color.Black },
+		"BackgroundColor":  func(o *cbz.StripOptions) { o.BackgroundColor = color.Black },
+		"RightToLeft":      func(o *cbz.StripOptions) { o.RightToLeft = true },
+		"SelectRange":      func(o *cbz.StripOptions) { o.SelectRange = false },
+		"StartPage":        func(o *cbz.StripOptions) { o.StartPage = 1 },
+		"EndPage":          func(o *cbz.StripOptions) { o.EndPage = 2 },
+		"SortField":        func(o *cbz.StripOptions) { o.SortField = "size" },
+		"SortDescending":   func(o *cbz.StripOptions) { o.SortDescending = true },
+		"SkipPages":        func(o *cbz.StripOptions) { o.SkipPages = []int{0} },
+		"AutoRotate":       func(o *cbz.StripOptions) { o.AutoRotate = true },
+		"CropMargin":       func(o *cbz.StripOptions) { o.CropMargin = 5 },
+		"SplitSpreads":     func(o *cbz.StripOptions) { o.SplitSpreads = true },
+		"SkipBlankPages":   func(o *cbz.StripOptions) { o.SkipBlankPages = false },
+		"BlankThreshold":   func(o *cbz.StripOptions) { o.BlankThreshold = 200 },
+		"Grayscale":        func(o *cbz.StripOptions) { o.Grayscale = true },
+		"Invert":           func(o *cbz.StripOptions) { o.Invert = true },
+		"Brightness":       func(o *cbz.StripOptions) { o.Brightness = 0.1 },
+		"Contrast":         func(o *cbz.StripOptions) { o.Contrast = 1.5 },
+		"Watermark":        func(o *cbz.StripOptions) { o.Watermark = "x" },
+		"WatermarkOpacity": func(o *cbz.StripOptions) { o.WatermarkOpacity = 0.2 },
+		"MaxWidth":         func(o *cbz.StripOptions) { o.MaxWidth = 100 },
+		"MaxHeight":        func(o *cbz.StripOptions) { o.MaxHeight = 100 },
+	}
+	// These do not change the strip's pixels.
+	ignored := map[string]func(*cbz.StripOptions){
+		"WorkerCount":     func(o *cbz.StripOptions) { o.WorkerCount = 2 },
+		"DiskThreshold":   func(o *cbz.StripOptions) { o.DiskThreshold = -1 },
+		"OnPageDecoded":   func(o *cbz.StripOptions) { o.OnPageDecoded = func(int, int, image.Image) {} },
+		"OnDecodeStart":   func(o *cbz.StripOptions) { o.OnDecodeStart = func(int) {} },
+		"MaxInputBytes":   func(o *cbz.StripOptions) { o.MaxInputBytes = 1 },
+		"MaxOutputPixels": func(o *cbz.StripOptions) { o.MaxOutputPixels = 1 },
+	}
+
+	fields := reflect.TypeOf(cbz.StripOptions{})
+	for i := 0; i < fields.NumField(); i++ {
+		name := fields.Field(i).Name
+		if changes[name] == nil && ignored[name] == nil {
+			t.Errorf("StripOptions.%s is neither in stripOptionsKey nor ignored by it", name)
+		}
+	}
+
+	// The range and blank threshold only count while their switches are on.
+	defaults := cbz.StripOptions{SelectRange: true, SkipBlankPages: true}
+	base := stripOptionsKey(defaults)
+	for name, change := range changes {
+		opts := defaults
+		change(&opts)
+		if stripOptionsKey(opts) == base {
+			t.Errorf("changing %s does not change the key", name)
+		}
+	}
+	for name, change := range ignored {
+		opts := defaults
+		change(&opts)
+		if stripOptionsKey(opts) != base {
+			t.Errorf("changing %s changes the key", name)
+		}
+	}
+
+	white := stripOptionsKey(cbz.StripOptions{GapColor: color.White})
+	if rgba := stripOptionsKey(cbz.StripOptions{GapColor: color.RGBA{255, 255, 255, 255}}); rgba != white {
+		t.Errorf("equal colors of different types give keys %q and %q", white, rgba)
+	}
+}
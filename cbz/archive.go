@@ -0,0 +1,369 @@
+package cbz
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nwaples/rardecode/v2"
+)
+
+// namedReadCloser is a single entry extracted from a comic book archive.
+type namedReadCloser struct {
+	Name string
+	entryInfo
+	io.ReadCloser
+}
+
+// entryInfo is the metadata an archive records about an entry, used to sort
+// pages by something other than their names.
+type entryInfo struct {
+	// ModTime is the entry's modification time.
+	ModTime time.Time
+
+	// Size is the entry's compressed size, or its size for tar archives,
+	// which are not compressed per entry.
+	Size int64
+}
+
+// peekHeader returns up to sniffLen leading bytes of the entry without
+// consuming them.
+func (e *namedReadCloser) peekHeader() ([]byte, error) {
+	header, r, err := peekHeader(e.ReadCloser)
+	e.ReadCloser = readCloser{Reader: r, Closer: e.ReadCloser}
+	return header, err
+}
+
+// entryName returns the name of entry, for sortPages.
+func entryName(entry namedReadCloser) string {
+	return entry.Name
+}
+
+// readCloser pairs a reader with the closer of the stream it wraps.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// IsArchiveFile reports whether filename has a supported archive extension.
+func IsArchiveFile(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	return ext == ".cbz" || ext == ".cbr" || ext == ".cbt" || ext == ".epub"
+}
+
+// openArchiveReader opens the archive at path and returns its regular file
+// entries, dispatching on the file extension. Entries that cannot be read,
+// such as zip entries with a bad checksum, are logged and their names
+// returned separately so the rest of the archive can still be used.
+func openArchiveReader(path string) ([]namedReadCloser, []string, error) {
+	return readArchiveEntries(path, nil)
+}
+
+// openArchivePages is openArchiveReader, but only reads the entries that are
+// page images or ComicInfo.xml into memory, so large attachments in an
+// archive cost nothing.
+func openArchivePages(path string) ([]namedReadCloser, []string, error) {
+	return readArchiveEntries(path, func(name string, header []byte) bool {
+		return isImageEntry(name, header) || isComicInfoEntry(name)
+	})
+}
+
+// readArchiveEntries reads the entries of the archive at path into memory.
+// With keep set, entries for which it returns false are skipped after
+// reading only their header.
+func readArchiveEntries(path string, keep func(name string, header []byte) bool) ([]namedReadCloser, []string, error) {
+	var entries []namedReadCloser
+	var corrupt []string
+	err := walkArchiveEntries(path, func(name string, info entryInfo, r io.Reader) (bool, error) {
+		if keep != nil {
+			header, br, err := peekHeader(r)
+			if err != nil {
+				slog.Warn("Skipping corrupt archive entry", "file", name, "error", err)
+				corrupt = append(corrupt, name)
+				return true, nil
+			}
+			if !keep(name, header) {
+				return true, nil
+			}
+			r = br
+		}
+
+		data, err := io.ReadAll(r)
+		if err != nil {
+			slog.Warn("Skipping corrupt archive entry", "file", name, "error", err)
+			corrupt = append(corrupt, name)
+			return true, nil
+		}
+
+		entries = append(entries, namedReadCloser{Name: name, entryInfo: info, ReadCloser: io.NopCloser(bytes.NewReader(data))})
+		return true, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return entries, corrupt, nil
+}
+
+// openArchiveEntry extracts the single entry called name from the archive at
+// path, leaving every other entry compressed.
+func openArchiveEntry(path, name string) (namedReadCloser, error) {
+	var data []byte
+	found := false
+	err := walkArchive(path, func(entryName string, r io.Reader) (bool, error) {
+		if entryName != name {
+			return true, nil
+		}
+
+		var err error
+		data, err = io.ReadAll(r)
+		if err != nil {
+			return false, fmt.Errorf("error reading file %s: %v", name, err)
+		}
+		found = true
+		return false, nil
+	})
+	if err != nil {
+		return namedReadCloser{}, err
+	}
+	if !found {
+		return namedReadCloser{}, fmt.Errorf("entry %s not found in archive", name)
+	}
+
+	return namedReadCloser{Name: name, ReadCloser: io.NopCloser(bytes.NewReader(data))}, nil
+}
+
+// walkArchive calls fn for each regular file in the archive at path, in
+// archive order, dispatching on the file extension. EPUBs are the exception:
+// only their page images are visited, in reading order (see walkEPUB). The
+// reader passed to fn is only valid for the duration of the call and is read
+// lazily, so entries fn does not read are never decompressed. Returning false
+// from fn stops the walk. Entry names are sanitized, and an unsafe name
+// aborts the walk.
+func walkArchive(path string, fn func(name string, r io.Reader) (bool, error)) error {
+	return walkArchiveEntries(path, func(name string, _ entryInfo, r io.Reader) (bool, error) {
+		return fn(name, r)
+	})
+}
+
+// walkArchiveEntries is walkArchive, also passing fn each entry's metadata.
+func walkArchiveEntries(path string, fn func(name string, info entryInfo, r io.Reader) (bool, error)) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".cbz":
+		reader, err := zip.OpenReader(path)
+		if err != nil {
+			return fmt.Errorf("error opening CBZ file: %v", err)
+		}
+		defer reader.Close()
+
+		if err := verifyZip(path, reader); err != nil {
+			return err
+		}
+
+		for _, file := range reader.File {
+			if file.FileInfo().IsDir() {
+				continue
+			}
+			if file.Flags&0x1 != 0 {
+				return fmt.Errorf("%w: %s", ErrArchiveEncrypted, file.Name)
+			}
+
+			name, err := sanitizeEntryName(file.Name)
+			if err != nil {
+				return err
+			}
+
+			r := &lazyReader{open: func() (io.ReadCloser, error) { return openZipEntry(file) }}
+			more, err := fn(name, zipEntryInfo(file), r)
+			r.Close()
+			if err != nil || !more {
+				return err
+			}
+		}
+		return nil
+	case ".cbr":
+		reader, err := rardecode.OpenReader(path)
+		if errors.Is(err, rardecode.ErrArchiveEncrypted) {
+			return ErrArchiveEncrypted
+		}
+		if err != nil {
+			return fmt.Errorf("error opening CBR file: %v", err)
+		}
+		defer reader.Close()
+
+		for {
+			header, err := reader.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if errors.Is(err, rardecode.ErrArchiveEncrypted) {
+				return ErrArchiveEncrypted
+			}
+			if err != nil {
+				return fmt.Errorf("error reading CBR file: %v", err)
+			}
+			if header.IsDir {
+				continue
+			}
+			if header.Encrypted {
+				return fmt.Errorf("%w: %s", ErrArchiveEncrypted, header.Name)
+			}
+
+			name, err := sanitizeEntryName(header.Name)
+			if err != nil {
+				return err
+			}
+			info := entryInfo{ModTime: header.ModificationTime, Size: header.PackedSize}
+			if more, err := fn(name, info, reader); err != nil || !more {
+				return err
+			}
+		}
+	case ".cbt":
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("error opening CBT file: %v", err)
+		}
+		defer file.Close()
+
+		reader := tar.NewReader(file)
+		for {
+			header, err := reader.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("error reading CBT file: %v", err)
+			}
+			if header.Typeflag != tar.TypeReg {
+				continue
+			}
+
+			name, err := sanitizeEntryName(header.Name)
+			if err != nil {
+				return err
+			}
+			info := entryInfo{ModTime: header.ModTime, Size: header.Size}
+			if more, err := fn(name, info, reader); err != nil || !more {
+				return err
+			}
+		}
+	case ".epub":
+		return walkEPUB(path, fn)
+	default:
+		return fmt.Errorf("unsupported archive format: %s", filepath.Ext(path))
+	}
+}
+
+// ErrArchiveEncrypted is returned when an archive or one of its entries is
+// password protected, which is not supported.
+var ErrArchiveEncrypted = errors.New("archive is password protected")
+
+// ErrArchiveTruncated is returned when a zip archive's central directory
+// points past the end of the file, as happens with partial downloads.
+var ErrArchiveTruncated = errors.New("archive is truncated")
+
+// verifyZip checks that every entry listed in the central directory of the
+// zip at path lies within the file. Sizes are taken from the 64-bit fields so
+// zip64 archives over 4 GB are checked correctly.
+func verifyZip(path string, reader *zip.ReadCloser) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("error opening CBZ file: %v", err)
+	}
+
+	for _, file := range reader.File {
+		offset, err := file.DataOffset()
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrArchiveTruncated, file.Name, err)
+		}
+		if end := uint64(offset) + file.CompressedSize64; end > uint64(info.Size()) {
+			return fmt.Errorf("%w: %s ends at byte %d of %d", ErrArchiveTruncated, file.Name, end, info.Size())
+		}
+	}
+	return nil
+}
+
+// ErrUnsafeEntryName is returned when an archive contains an entry whose
+// name is absolute or escapes the archive root.
+var ErrUnsafeEntryName = errors.New("unsafe entry name in archive")
+
+// sanitizeEntryName normalizes an archive entry name to a clean, slash
+// separated relative path, rejecting absolute paths and ".." components so
+// that names can never be used to reach outside an extraction directory.
+func sanitizeEntryName(name string) (string, error) {
+	slashed := strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(slashed, "/") || (len(slashed) >= 2 && slashed[1] == ':') {
+		return "", fmt.Errorf("%w: %q is absolute", ErrUnsafeEntryName, name)
+	}
+
+	for _, part := range strings.Split(slashed, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("%w: %q escapes the archive", ErrUnsafeEntryName, name)
+		}
+	}
+
+	return path.Clean(slashed), nil
+}
+
+// zipEntryInfo returns the metadata of a zip entry.
+func zipEntryInfo(file *zip.File) entryInfo {
+	return entryInfo{ModTime: file.Modified, Size: int64(file.CompressedSize64)}
+}
+
+// bzip2Magic starts every bzip2 stream.
+const bzip2Magic = "BZh"
+
+// openZipEntry opens file for reading. Some archivers bzip2 each image before
+// storing it in the zip, so entries that start with the bzip2 magic are
+// decompressed transparently.
+func openZipEntry(file *zip.File) (io.ReadCloser, error) {
+	rc, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(rc)
+	magic, err := br.Peek(len(bzip2Magic))
+	if err != nil && err != io.EOF {
+		rc.Close()
+		return nil, err
+	}
+	if string(magic) == bzip2Magic {
+		return readCloser{Reader: bzip2.NewReader(br), Closer: rc}, nil
+	}
+	return readCloser{Reader: br, Closer: rc}, nil
+}
+
+// lazyReader defers opening a zip entry until the first Read.
+type lazyReader struct {
+	open func() (io.ReadCloser, error)
+	rc   io.ReadCloser
+}
+
+func (l *lazyReader) Read(p []byte) (int, error) {
+	if l.rc == nil {
+		rc, err := l.open()
+		if err != nil {
+			return 0, err
+		}
+		l.rc = rc
+	}
+	return l.rc.Read(p)
+}
+
+func (l *lazyReader) Close() error {
+	if l.rc == nil {
+		return nil
+	}
+	return l.rc.Close()
+}
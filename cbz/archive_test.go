@@ -0,0 +1,107 @@
+package cbz
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// sparseFile writes runs of zeros by seeking past them, so a multi-gigabyte
+// archive of zero padding costs almost no disk space.
+type sparseFile struct {
+	*os.File
+}
+
+func (f sparseFile) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if b != 0 {
+			return f.File.Write(p)
+		}
+	}
+	if _, err := f.Seek(int64(len(p)), io.SeekCurrent); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeZip64Archive writes an archive at path holding a stored entry that
+// claims paddingSize bytes, of which written are actually written, followed
+// by entries. The padding is never read, so its CRC is left zero.
+func writeZip64Archive(t *testing.T, path string, paddingSize, written int64, entries ...archiveEntry) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	zw := zip.NewWriter(sparseFile{file})
+	w, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               "padding.bin",
+		Method:             zip.Store,
+		CompressedSize64:   uint64(paddingSize),
+		UncompressedSize64: uint64(paddingSize),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk := make([]byte, 1<<20)
+	for n := int64(0); n < written; n += int64(len(chunk)) {
+		if _, err := w.Write(chunk[:min(int64(len(chunk)), written-n)]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, entry := range entries {
+		w, err := zw.Create(entry.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(entry.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestZip64Archive(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes a 5 GB sparse archive")
+	}
+
+	// The page lies past the 4 GB offsets a plain zip can express.
+	const size = 5 << 30
+	path := filepath.Join(t.TempDir(), "zip64.cbz")
+	writeZip64Archive(t, path, size, size, archiveEntry{"001.png", pngPage(120, 200)})
+
+	img, result, err := CreateWebtoonStrip(context.Background(), path, StripOptions{})
+	if err != nil {
+		t.Fatalf("CreateWebtoonStrip: %v", err)
+	}
+	defer ReleaseStrip(img)
+	if got := img.Bounds().Size(); got != image.Pt(120, 200) {
+		t.Errorf("strip size = %v, want (120,200)", got)
+	}
+	if result.PageCount != 1 {
+		t.Errorf("PageCount = %d, want 1", result.PageCount)
+	}
+}
+
+func TestTruncatedArchive(t *testing.T) {
+	// The central directory survives, but the last entry claims more data
+	// than the file holds, as in a partial download.
+	path := filepath.Join(t.TempDir(), "truncated.cbz")
+	writeZip64Archive(t, path, 5<<30, 1<<20)
+
+	_, _, err := CreateWebtoonStrip(context.Background(), path, StripOptions{})
+	if !errors.Is(err, ErrArchiveTruncated) {
+		t.Fatalf("CreateWebtoonStrip error = %v, want ErrArchiveTruncated", err)
+	}
+}
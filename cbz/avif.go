@@ -0,0 +1,16 @@
+//go:build avif
+
+package cbz
+
+import "github.com/gen2brain/avif"
+
+// AVIF probing is expensive, so it is registered as an optional decoder and
+// only tried once every built-in format has failed.
+func init() {
+	optionalDecoders = append(optionalDecoders, optionalDecoder{
+		format:       "avif",
+		extensions:   []string{".avif"},
+		decode:       avif.Decode,
+		decodeConfig: avif.DecodeConfig,
+	})
+}
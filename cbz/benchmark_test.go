@@ -0,0 +1,81 @@
+package cbz
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	webpenc "github.com/chai2010/webp"
+)
+
+// benchmarkPageWidth and benchmarkPageHeight are the size of the pages in
+// the benchmark archives, typical of a webtoon page.
+const (
+	benchmarkPageWidth  = 800
+	benchmarkPageHeight = 1280
+)
+
+// benchmarkArchive writes an archive of n JPEG pages for b.
+func benchmarkArchive(b *testing.B, n int) string {
+	b.Helper()
+	page := jpegPage(benchmarkPageWidth, benchmarkPageHeight)
+	entries := make([]archiveEntry, n)
+	for i := range entries {
+		entries[i] = archiveEntry{fmt.Sprintf("%03d.jpg", i+1), page}
+	}
+	return writeTestArchive(b, "bench.cbz", entries...)
+}
+
+func benchmarkCreateWebtoonStrip(b *testing.B, pages int) {
+	path := benchmarkArchive(b, pages)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		img, _, err := CreateWebtoonStrip(context.Background(), path, StripOptions{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		ReleaseStrip(img)
+	}
+}
+
+func BenchmarkCreateWebtoonStrip_10pages(b *testing.B) {
+	benchmarkCreateWebtoonStrip(b, 10)
+}
+
+func BenchmarkCreateWebtoonStrip_100pages(b *testing.B) {
+	benchmarkCreateWebtoonStrip(b, 100)
+}
+
+func benchmarkDecode(b *testing.B, data []byte) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := DecodeImage(bytes.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeJPEG(b *testing.B) {
+	benchmarkDecode(b, jpegPage(benchmarkPageWidth, benchmarkPageHeight))
+}
+
+func BenchmarkDecodePNG(b *testing.B) {
+	benchmarkDecode(b, pngPage(benchmarkPageWidth, benchmarkPageHeight))
+}
+
+func BenchmarkDecodeWebP(b *testing.B) {
+	benchmarkDecode(b, webpPage(benchmarkPageWidth, benchmarkPageHeight))
+}
+
+// webpPage returns testPage(w, h) encoded as a lossy WebP.
+func webpPage(w, h int) []byte {
+	var buf bytes.Buffer
+	if err := webpenc.Encode(&buf, testPage(w, h), &webpenc.Options{Quality: 90}); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
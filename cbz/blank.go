@@ -0,0 +1,33 @@
+package cbz
+
+import "image"
+
+// DefaultBlankThreshold is the average luminance, from 0 to 255, above which
+// a page is considered blank when StripOptions.BlankThreshold is zero.
+const DefaultBlankThreshold = 250
+
+// blankSampleGrid is the number of rows and columns of pixels isBlankPage
+// samples.
+const blankSampleGrid = 10
+
+// isBlankPage reports whether img is an empty separator page: whether the
+// average luminance of a blankSampleGrid×blankSampleGrid grid of pixels
+// spread across it exceeds threshold.
+func isBlankPage(img image.Image, threshold float64) bool {
+	if threshold == 0 {
+		threshold = DefaultBlankThreshold
+	}
+
+	bounds := img.Bounds()
+	var sum float64
+	for i := range blankSampleGrid {
+		y := bounds.Min.Y + (2*i+1)*bounds.Dy()/(2*blankSampleGrid)
+		for j := range blankSampleGrid {
+			x := bounds.Min.X + (2*j+1)*bounds.Dx()/(2*blankSampleGrid)
+			r, g, b, _ := img.At(x, y).RGBA()
+			sum += (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 257
+		}
+	}
+
+	return sum/(blankSampleGrid*blankSampleGrid) > threshold
+}
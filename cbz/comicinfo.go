@@ -0,0 +1,68 @@
+package cbz
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// ErrNoComicInfo is returned by ReadComicInfo when the archive has no
+// ComicInfo.xml entry.
+var ErrNoComicInfo = errors.New("archive has no ComicInfo.xml")
+
+// ComicInfo holds the commonly used fields of a ComicRack ComicInfo.xml file.
+// Fields missing from the file are left at their zero value.
+type ComicInfo struct {
+	Title       string `xml:"Title" json:"title,omitempty"`
+	Series      string `xml:"Series" json:"series,omitempty"`
+	Number      string `xml:"Number" json:"number,omitempty"`
+	Volume      int    `xml:"Volume" json:"volume,omitempty"`
+	Summary     string `xml:"Summary" json:"summary,omitempty"`
+	Year        int    `xml:"Year" json:"year,omitempty"`
+	Month       int    `xml:"Month" json:"month,omitempty"`
+	Writer      string `xml:"Writer" json:"writer,omitempty"`
+	Penciller   string `xml:"Penciller" json:"penciller,omitempty"`
+	Publisher   string `xml:"Publisher" json:"publisher,omitempty"`
+	LanguageISO string `xml:"LanguageISO" json:"languageISO,omitempty"`
+	Manga       string `xml:"Manga" json:"manga,omitempty"`
+	PageCount   int    `xml:"PageCount" json:"pageCount,omitempty"`
+}
+
+// ReadComicInfo parses the ComicInfo.xml entry of the archive at cbzFilePath.
+// It returns ErrNoComicInfo if the archive does not contain one.
+func ReadComicInfo(cbzFilePath string) (*ComicInfo, error) {
+	var info *ComicInfo
+	err := walkArchive(cbzFilePath, func(name string, r io.Reader) (bool, error) {
+		if !isComicInfoEntry(name) {
+			return true, nil
+		}
+
+		var err error
+		info, err = parseComicInfo(r)
+		return false, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		return nil, ErrNoComicInfo
+	}
+	return info, nil
+}
+
+// isComicInfoEntry reports whether name is a ComicInfo.xml file, matched
+// case-insensitively in any directory of the archive.
+func isComicInfoEntry(name string) bool {
+	return strings.EqualFold(path.Base(name), "ComicInfo.xml")
+}
+
+func parseComicInfo(r io.Reader) (*ComicInfo, error) {
+	var info ComicInfo
+	if err := xml.NewDecoder(r).Decode(&info); err != nil {
+		return nil, fmt.Errorf("error parsing ComicInfo.xml: %v", err)
+	}
+	return &info, nil
+}
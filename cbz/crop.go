@@ -0,0 +1,38 @@
+package cbz
+
+import (
+	"errors"
+	"image"
+	"log/slog"
+
+	"golang.org/x/image/draw"
+)
+
+// ErrInvalidCropMargin is returned when StripOptions.CropMargin is negative.
+var ErrInvalidCropMargin = errors.New("crop margin must not be negative")
+
+// cropMargins trims margin pixels from every edge of each decoded page, such
+// as the borders left by a scanner. Pages too small to keep any pixels are
+// left whole, and nil images, which failed to decode, are left alone.
+func cropMargins(pages []namedReadCloser, decoded []image.Image, margin int) {
+	for i, img := range decoded {
+		if img == nil {
+			continue
+		}
+
+		bounds := img.Bounds()
+		if bounds.Dx() <= 2*margin || bounds.Dy() <= 2*margin {
+			slog.Info("Not cropping page smaller than the margins", "file", pages[i].Name, "width", bounds.Dx(), "height", bounds.Dy(), "margin", margin)
+			continue
+		}
+		decoded[i] = cropImage(img, bounds.Inset(margin))
+	}
+}
+
+// cropImage copies the part of img inside r into a new image whose bounds
+// start at the origin, as CompositeStrip expects of its pages.
+func cropImage(img image.Image, r image.Rectangle) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, r.Min, draw.Src)
+	return dst
+}
@@ -0,0 +1,124 @@
+package cbz
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+)
+
+// toRGBA converts img to *image.RGBA, returning it unchanged if it already is
+// one.
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	return rgba
+}
+
+// optionalDecoder is an input format compiled in behind a build tag.
+type optionalDecoder struct {
+	format       string
+	extensions   []string
+	decode       func(io.Reader) (image.Image, error)
+	decodeConfig func(io.Reader) (image.Config, error)
+}
+
+// optionalDecoders are tried after the built-in formats, in order. Files
+// guarded by build tags register themselves here from init.
+var optionalDecoders []optionalDecoder
+
+// isImageFile reports whether filename has a supported image extension.
+func isImageFile(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	switch ext {
+	case ".jpg", ".jpeg", ".png", ".webp", ".gif", ".bmp", ".tif", ".tiff":
+		return true
+	}
+
+	for _, dec := range optionalDecoders {
+		if slices.Contains(dec.extensions, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// DecodeImage decodes a single page, returning the image and the name of the
+// format it was decoded as. JPEGs are turned upright according to their EXIF
+// orientation tag, which image/jpeg ignores and which no output keeps.
+// Malformed data yields an error, even if it makes a decoder panic.
+func DecodeImage(r io.Reader) (image.Image, string, error) {
+	return decodePage(r, true, 0)
+}
+
+// decodeImage tries each supported decoder on data in turn.
+func decodeImage(data []byte) (image.Image, string, error) {
+	// Try the format named by the magic bytes first, so mislabelled files
+	// decode correctly on the first attempt
+	format := sniffFormat(data)
+	if decode, ok := sniffedDecoders[format]; ok {
+		if img, err := decode(bytes.NewReader(data)); err == nil {
+			return img, format, nil
+		}
+	}
+
+	// Try decoding as JPEG
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err == nil {
+		return img, "jpeg", nil
+	}
+
+	// Try decoding as PNG
+	img, err = png.Decode(bytes.NewReader(data))
+	if err == nil {
+		return img, "png", nil
+	}
+
+	// Try decoding as WebP
+	img, err = webp.Decode(bytes.NewReader(data))
+	if err == nil {
+		return img, "webp", nil
+	}
+
+	// Try decoding as GIF. Only the first frame of an animated GIF is used.
+	img, err = decodeGIF(bytes.NewReader(data))
+	if err == nil {
+		return img, "gif", nil
+	}
+
+	// Try decoding as BMP
+	img, err = bmp.Decode(bytes.NewReader(data))
+	if err == nil {
+		return img, "bmp", nil
+	}
+
+	// Try decoding as TIFF
+	img, err = tiff.Decode(bytes.NewReader(data))
+	if err == nil {
+		return img, "tiff", nil
+	}
+
+	for _, dec := range optionalDecoders {
+		img, err = dec.decode(bytes.NewReader(data))
+		if err == nil {
+			return img, dec.format, nil
+		}
+	}
+
+	return nil, "", fmt.Errorf("unsupported image format")
+}
@@ -0,0 +1,134 @@
+package cbz
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"testing"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+func FuzzDecodeImage(f *testing.F) {
+	f.Add(jpegPage(16, 12))
+	f.Add(pngPage(16, 12))
+	f.Add(webpPage(16, 12))
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		config, _, err := DecodeImageConfig(bytes.NewReader(data))
+		// A few header bytes can claim an image of gigapixels; decoding it
+		// would only measure the machine's memory.
+		if err == nil && int64(config.Width)*int64(config.Height) > 1<<22 {
+			t.Skip()
+		}
+
+		img, _, err := DecodeImage(bytes.NewReader(data))
+		if err == nil && img == nil {
+			t.Fatal("DecodeImage returned neither an image nor an error")
+		}
+	})
+}
+
+// twoColorPage returns a w by h page whose top half is top and bottom half
+// is bottom.
+func twoColorPage(w, h int, top, bottom color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, image.Rect(0, 0, w, h/2), image.NewUniform(top), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, h/2, w, h), image.NewUniform(bottom), image.Point{}, draw.Src)
+	return img
+}
+
+// assertColorAt fails t unless img's pixel at (x, y) is want.
+func assertColorAt(t *testing.T, img image.Image, x, y int, want color.Color) {
+	t.Helper()
+	if !sameColor(img.At(x, y), want) {
+		t.Errorf("pixel at (%d,%d) = %v, want %v", x, y, img.At(x, y), want)
+	}
+}
+
+var (
+	red   = color.RGBA{255, 0, 0, 255}
+	green = color.RGBA{0, 255, 0, 255}
+	blue  = color.RGBA{0, 0, 255, 255}
+)
+
+func TestCompositeGIFPage(t *testing.T) {
+	var buf bytes.Buffer
+	page := twoColorPage(40, 30, red, blue)
+	paletted := image.NewPaletted(page.Bounds(), color.Palette{red, green, blue})
+	draw.Draw(paletted, paletted.Bounds(), page, image.Point{}, draw.Src)
+	if err := gif.Encode(&buf, paletted, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var second bytes.Buffer
+	if err := png.Encode(&second, twoColorPage(40, 20, green, green)); err != nil {
+		t.Fatal(err)
+	}
+	path := writeTestArchive(t, "gif.cbz",
+		archiveEntry{"001.gif", buf.Bytes()},
+		archiveEntry{"002.png", second.Bytes()},
+	)
+
+	strip, result, err := CreateWebtoonStrip(context.Background(), path, StripOptions{})
+	if err != nil {
+		t.Fatalf("CreateWebtoonStrip: %v", err)
+	}
+	if result.FormatCounts["gif"] != 1 {
+		t.Errorf("FormatCounts = %v, want one gif", result.FormatCounts)
+	}
+	if got := strip.Bounds().Size(); got != image.Pt(40, 50) {
+		t.Fatalf("strip size = %v, want (40,50)", got)
+	}
+	assertColorAt(t, strip, 20, 5, red)
+	assertColorAt(t, strip, 20, 25, blue)
+	assertColorAt(t, strip, 20, 40, green)
+}
+
+func TestCompositeBMPAndTIFFPages(t *testing.T) {
+	encoders := []struct {
+		format string
+		ext    string
+		encode func(io.Writer, image.Image) error
+	}{
+		{"bmp", ".bmp", bmp.Encode},
+		{"tiff", ".tif", func(w io.Writer, img image.Image) error { return tiff.Encode(w, img, nil) }},
+	}
+
+	for _, enc := range encoders {
+		t.Run(enc.format, func(t *testing.T) {
+			var first, second bytes.Buffer
+			if err := enc.encode(&first, twoColorPage(40, 30, red, blue)); err != nil {
+				t.Fatal(err)
+			}
+			if err := enc.encode(&second, twoColorPage(40, 20, green, green)); err != nil {
+				t.Fatal(err)
+			}
+			path := writeTestArchive(t, enc.format+".cbz",
+				archiveEntry{"001" + enc.ext, first.Bytes()},
+				archiveEntry{"002" + enc.ext, second.Bytes()},
+			)
+
+			strip, result, err := CreateWebtoonStrip(context.Background(), path, StripOptions{})
+			if err != nil {
+				t.Fatalf("CreateWebtoonStrip: %v", err)
+			}
+			if result.FormatCounts[enc.format] != 2 {
+				t.Errorf("FormatCounts = %v, want two %s", result.FormatCounts, enc.format)
+			}
+			if got := strip.Bounds().Size(); got != image.Pt(40, 50) {
+				t.Fatalf("strip size = %v, want (40,50)", got)
+			}
+			assertColorAt(t, strip, 20, 5, red)
+			assertColorAt(t, strip, 20, 25, blue)
+			assertColorAt(t, strip, 20, 40, green)
+		})
+	}
+}
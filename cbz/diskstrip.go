@@ -0,0 +1,137 @@
+package cbz
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"log/slog"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// DefaultDiskThreshold is the strip size above which CompositeStrip composes
+// into a temporary file when StripOptions.DiskThreshold is zero.
+const DefaultDiskThreshold = 512 << 20
+
+// diskBandRows is the number of rows compositeToDisk renders at a time.
+const diskBandRows = 256
+
+// compositeToDisk renders the strip in bands of diskBandRows rows, applies
+// the filters in opts to each and appends its pixels to a temporary file, so
+// only one band of the strip is ever held in memory. The returned image reads
+// its pixels back from the file until ReleaseStrip closes it.
+func compositeToDisk(pages []image.Image, width, height int, opts StripOptions, gapColor color.Color) (image.Image, error) {
+	file, err := os.CreateTemp("", "cbz-strip-*.raw")
+	if err != nil {
+		return nil, fmt.Errorf("error creating temp file: %v", err)
+	}
+	// Unlinking the file while it is open lets the OS reclaim the space as
+	// soon as it is closed. Where that is not allowed, the finalizer below
+	// removes it instead.
+	os.Remove(file.Name())
+
+	area := image.Rect(0, 0, width, height)
+	img := &fileImage{file: file, rect: area, gray: opts.Grayscale, opaque: true, rowY: -1}
+	runtime.SetFinalizer(img, (*fileImage).close)
+
+	w := bufio.NewWriterSize(file, 1<<20)
+	for y := 0; y < height; y += diskBandRows {
+		band := image.NewRGBA(image.Rect(0, y, width, min(y+diskBandRows, height)))
+		drawStrip(band, pages, opts, gapColor)
+
+		var pix []byte
+		switch filtered := applyFilters(band, area, opts).(type) {
+		case *image.Gray:
+			pix = filtered.Pix
+		case *image.RGBA:
+			pix = filtered.Pix
+			for i := 3; i < len(pix) && img.opaque; i += 4 {
+				img.opaque = pix[i] == 0xff
+			}
+		}
+
+		if _, err := w.Write(pix); err != nil {
+			img.close()
+			return nil, fmt.Errorf("error writing temp file: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		img.close()
+		return nil, fmt.Errorf("error writing temp file: %v", err)
+	}
+
+	slog.Info("Composed strip on disk", "width", width, "height", height)
+	return img, nil
+}
+
+// fileImage is an RGBA or grayscale image whose rows are stored one after
+// another in a file. Reading pixels in row order, as the image encoders do,
+// costs one read per row.
+type fileImage struct {
+	file   *os.File
+	rect   image.Rectangle
+	gray   bool
+	opaque bool
+
+	mu   sync.Mutex
+	rowY int
+	row  []byte
+}
+
+func (m *fileImage) ColorModel() color.Model {
+	if m.gray {
+		return color.GrayModel
+	}
+	return color.RGBAModel
+}
+
+func (m *fileImage) Bounds() image.Rectangle { return m.rect }
+
+// Opaque reports whether every pixel is fully opaque, which lets image/png
+// skip scanning the file to find out.
+func (m *fileImage) Opaque() bool { return m.gray || m.opaque }
+
+func (m *fileImage) At(x, y int) color.Color {
+	if !(image.Point{X: x, Y: y}.In(m.rect)) {
+		return m.ColorModel().Convert(color.Transparent)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if y != m.rowY {
+		bpp := 4
+		if m.gray {
+			bpp = 1
+		}
+		if m.row == nil {
+			m.row = make([]byte, m.rect.Dx()*bpp)
+		}
+		if _, err := m.file.ReadAt(m.row, int64(y)*int64(len(m.row))); err != nil {
+			slog.Error("Error reading strip from temp file", "row", y, "error", err)
+			clear(m.row)
+		}
+		m.rowY = y
+	}
+
+	if m.gray {
+		return color.Gray{Y: m.row[x]}
+	}
+	p := m.row[4*x : 4*x+4]
+	return color.RGBA{R: p[0], G: p[1], B: p[2], A: p[3]}
+}
+
+// readRow copies row y, relative to the top of m, into dst. Unlike At it
+// is safe to call from several goroutines at once.
+func (m *fileImage) readRow(y int, dst []byte) error {
+	_, err := m.file.ReadAt(dst, int64(y)*int64(len(dst)))
+	return err
+}
+
+// close releases the temporary file backing m.
+func (m *fileImage) close() {
+	m.file.Close()
+	os.Remove(m.file.Name())
+}
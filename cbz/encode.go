@@ -0,0 +1,97 @@
+package cbz
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"runtime"
+
+	webpenc "github.com/chai2010/webp"
+)
+
+// EncodeOptions controls how EncodeWithOptions encodes an image. The zero
+// value uses png.DefaultCompression and a quality of 0.
+type EncodeOptions struct {
+	// Quality is the JPEG or WebP quality from 1 to 100. Lossless formats
+	// ignore it; PDFs embed the image as a JPEG of this quality.
+	Quality int
+
+	// PNGCompression is the zlib compression level used for PNG output.
+	PNGCompression png.CompressionLevel
+
+	// Quantize reduces PNG output to a palette of at most 256 colors, as
+	// pngquant does, which shrinks black-and-white scans considerably for
+	// little visible loss. It requires the quantize build tag and is
+	// ignored without it; see QuantizeSupported. Grayscale images already
+	// fit a palette and are left as they are.
+	Quantize bool
+}
+
+// ErrTooLargeForFormat is returned by EncodeWithOptions when an image is
+// wider or taller than its format can store.
+var ErrTooLargeForFormat = errors.New("image too large for output format")
+
+// maxFormatDimensions is the largest width or height each format can store.
+// PDFs embed a JPEG. PNG is only limited by memory.
+var maxFormatDimensions = map[string]int{
+	"jpeg": 65535,
+	"webp": 16383,
+	"pdf":  65535,
+}
+
+// FitsFormat reports whether an image of the given size can be encoded as
+// format.
+func FitsFormat(format string, size image.Point) bool {
+	limit, ok := maxFormatDimensions[format]
+	return !ok || (size.X <= limit && size.Y <= limit)
+}
+
+// Encode writes img to w in the given format ("png", "jpeg", "webp" or
+// "pdf"). Quality is ignored for lossless formats; PDFs embed the image as a
+// JPEG of that quality. Only pixel data is written, so metadata such as EXIF
+// GPS coordinates or camera details in the source pages never reaches the
+// output.
+func Encode(w io.Writer, img image.Image, format string, quality int) error {
+	return EncodeWithOptions(w, img, format, EncodeOptions{Quality: quality})
+}
+
+// EncodeWithOptions is Encode with control over format-specific settings.
+// Images too large for format, such as WebP strips taller than 16383 pixels,
+// are rejected with ErrTooLargeForFormat before anything is written.
+func EncodeWithOptions(w io.Writer, img image.Image, format string, opts EncodeOptions) error {
+	if size := img.Bounds().Size(); !FitsFormat(format, size) {
+		return fmt.Errorf("%w: %dx%d exceeds the %d pixel limit of %s", ErrTooLargeForFormat, size.X, size.Y, maxFormatDimensions[format], format)
+	}
+
+	switch format {
+	case "png":
+		if _, gray := img.(*image.Gray); opts.Quantize && quantizeImage != nil && !gray {
+			paletted, err := quantizeImage(img)
+			if err != nil {
+				return err
+			}
+			encoder := png.Encoder{CompressionLevel: opts.PNGCompression}
+			return encoder.Encode(w, paletted)
+		}
+		// Tall strips are compressed in parallel bands; image/png would
+		// use a single core.
+		if rows, ok := newPNGRows(img); ok && rows.height >= parallelPNGMinRows && runtime.GOMAXPROCS(0) > 1 {
+			return encodePNGBands(w, rows, opts.PNGCompression)
+		}
+		encoder := png.Encoder{
+			CompressionLevel: opts.PNGCompression,
+		}
+		return encoder.Encode(w, img)
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: opts.Quality})
+	case "webp":
+		return webpenc.Encode(w, img, &webpenc.Options{Quality: float32(opts.Quality)})
+	case "pdf":
+		return encodePDF(w, img, opts.Quality)
+	default:
+		return fmt.Errorf("unsupported output format: %s", format)
+	}
+}
@@ -0,0 +1,130 @@
+package cbz
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"testing"
+)
+
+func TestEncodeWebPRoundTrip(t *testing.T) {
+	src := testPage(256, 192)
+
+	var buf bytes.Buffer
+	if err := EncodeWithOptions(&buf, src, "webp", EncodeOptions{Quality: 100}); err != nil {
+		t.Fatalf("EncodeWithOptions: %v", err)
+	}
+
+	img, format, err := DecodeImage(&buf)
+	if err != nil {
+		t.Fatalf("DecodeImage: %v", err)
+	}
+	if format != "webp" {
+		t.Fatalf("decoded format = %q, want webp", format)
+	}
+	if img.Bounds() != src.Bounds() {
+		t.Fatalf("decoded bounds = %v, want %v", img.Bounds(), src.Bounds())
+	}
+
+	// WebP is lossy, subsamples chroma and stores video-range YCbCr, so
+	// compare the mean channel difference against a tolerance rather than
+	// every pixel exactly. A broken encoder is off by far more.
+	const tolerance = 8.0
+	var total uint64
+	for y := 0; y < src.Bounds().Dy(); y++ {
+		for x := 0; x < src.Bounds().Dx(); x++ {
+			r1, g1, b1, _ := src.At(x, y).RGBA()
+			r2, g2, b2, _ := img.At(x, y).RGBA()
+			total += uint64(absDiff(r1, r2)>>8 + absDiff(g1, g2)>>8 + absDiff(b1, b2)>>8)
+		}
+	}
+	mean := float64(total) / float64(3*src.Bounds().Dx()*src.Bounds().Dy())
+	if mean > tolerance {
+		t.Errorf("mean channel difference = %.2f, want at most %.1f", mean, tolerance)
+	}
+}
+
+func TestEncodeTooLargeForFormat(t *testing.T) {
+	tests := []struct {
+		format string
+		size   image.Point
+		fits   bool
+	}{
+		{"webp", image.Pt(200, 16383), true},
+		{"webp", image.Pt(200, 16384), false},
+		{"webp", image.Pt(16384, 10), false},
+		{"jpeg", image.Pt(10, 65536), false},
+		{"pdf", image.Pt(10, 65536), false},
+		{"png", image.Pt(1, 70000), true},
+	}
+
+	for _, tt := range tests {
+		if got := FitsFormat(tt.format, tt.size); got != tt.fits {
+			t.Errorf("FitsFormat(%q, %v) = %v, want %v", tt.format, tt.size, got, tt.fits)
+		}
+		if tt.fits {
+			continue
+		}
+
+		var buf bytes.Buffer
+		img := image.NewGray(image.Rectangle{Max: tt.size})
+		err := EncodeWithOptions(&buf, img, tt.format, EncodeOptions{Quality: 80})
+		if !errors.Is(err, ErrTooLargeForFormat) {
+			t.Errorf("EncodeWithOptions(%q, %v) error = %v, want ErrTooLargeForFormat", tt.format, tt.size, err)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("EncodeWithOptions(%q, %v) wrote %d bytes before failing", tt.format, tt.size, buf.Len())
+		}
+	}
+}
+
+func absDiff(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// hasAPP1 reports whether the JPEG in data has an APP1 segment, where EXIF
+// and XMP metadata live, before its image data.
+func hasAPP1(data []byte) bool {
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker := data[i+1]
+		if marker == 0xE1 {
+			return true
+		}
+		if marker == 0xDA { // start of scan
+			return false
+		}
+		i += 2 + int(data[i+2])<<8 + int(data[i+3])
+	}
+	return false
+}
+
+func TestEncodeJPEGDropsEXIF(t *testing.T) {
+	page := withOrientation(jpegPage(120, 200), 1)
+	if !hasAPP1(page) {
+		t.Fatal("source page has no APP1 segment")
+	}
+	path := writeTestArchive(t, "exif.cbz", archiveEntry{"001.jpg", page})
+
+	strip, _, err := CreateWebtoonStrip(context.Background(), path, StripOptions{})
+	if err != nil {
+		t.Fatalf("CreateWebtoonStrip: %v", err)
+	}
+	single, _, err := ExtractPage(path, 0)
+	if err != nil {
+		t.Fatalf("ExtractPage: %v", err)
+	}
+
+	for name, img := range map[string]image.Image{"strip": strip, "page": single} {
+		var buf bytes.Buffer
+		if err := EncodeWithOptions(&buf, img, "jpeg", EncodeOptions{Quality: 90}); err != nil {
+			t.Fatalf("EncodeWithOptions: %v", err)
+		}
+		if hasAPP1(buf.Bytes()) || bytes.Contains(buf.Bytes(), []byte("Exif\x00\x00")) {
+			t.Errorf("encoded %s carries EXIF data", name)
+		}
+	}
+}
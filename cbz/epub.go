@@ -0,0 +1,223 @@
+package cbz
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ErrEPUBNotImages is returned for EPUBs whose spine holds text rather than
+// page images, such as novels, which cannot be turned into a strip.
+var ErrEPUBNotImages = errors.New("EPUB contains text rather than page images")
+
+// epubContainer is META-INF/container.xml, which points at the OPF package
+// document.
+type epubContainer struct {
+	Rootfiles []struct {
+		FullPath string `xml:"full-path,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+// epubPackage holds the parts of the OPF package document needed to find
+// the pages in reading order.
+type epubPackage struct {
+	Manifest []struct {
+		ID        string `xml:"id,attr"`
+		Href      string `xml:"href,attr"`
+		MediaType string `xml:"media-type,attr"`
+	} `xml:"manifest>item"`
+	Spine []struct {
+		IDRef string `xml:"idref,attr"`
+	} `xml:"spine>itemref"`
+}
+
+// walkEPUB is walkArchiveEntries for EPUBs. Only page images are visited, in
+// the order the OPF spine reads them, rather than every entry in archive
+// order.
+func walkEPUB(epubPath string, fn func(name string, info entryInfo, r io.Reader) (bool, error)) error {
+	reader, err := zip.OpenReader(epubPath)
+	if err != nil {
+		return fmt.Errorf("error opening EPUB file: %v", err)
+	}
+	defer reader.Close()
+
+	if err := verifyZip(epubPath, reader); err != nil {
+		return err
+	}
+
+	files := make(map[string]*zip.File)
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		name, err := sanitizeEntryName(file.Name)
+		if err != nil {
+			return err
+		}
+		files[name] = file
+	}
+
+	images, err := epubImages(files)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range images {
+		file := files[name]
+		if file.Flags&0x1 != 0 {
+			return fmt.Errorf("%w: %s", ErrArchiveEncrypted, file.Name)
+		}
+
+		r := &lazyReader{open: func() (io.ReadCloser, error) { return openZipEntry(file) }}
+		more, err := fn(name, zipEntryInfo(file), r)
+		r.Close()
+		if err != nil || !more {
+			return err
+		}
+	}
+	return nil
+}
+
+// epubImages returns the names of the page images among files in spine
+// order. Spine items may be images themselves or XHTML documents wrapping
+// them in <img> or SVG <image> elements. Images referenced more than once
+// are listed once.
+func epubImages(files map[string]*zip.File) ([]string, error) {
+	var container epubContainer
+	if err := readEPUBXML(files, "META-INF/container.xml", &container); err != nil {
+		return nil, err
+	}
+	if len(container.Rootfiles) == 0 {
+		return nil, fmt.Errorf("error reading EPUB: container.xml names no package document")
+	}
+
+	opfPath := path.Clean(container.Rootfiles[0].FullPath)
+	var pkg epubPackage
+	if err := readEPUBXML(files, opfPath, &pkg); err != nil {
+		return nil, err
+	}
+
+	items := make(map[string]int, len(pkg.Manifest))
+	for i, item := range pkg.Manifest {
+		items[item.ID] = i
+	}
+
+	var images []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		if _, ok := files[name]; !ok {
+			slog.Warn("Skipping missing EPUB image", "file", name)
+			return
+		}
+		images = append(images, name)
+	}
+
+	for _, ref := range pkg.Spine {
+		i, ok := items[ref.IDRef]
+		if !ok {
+			continue
+		}
+		item := pkg.Manifest[i]
+		name := resolveEPUBHref(opfPath, item.Href)
+
+		if strings.HasPrefix(item.MediaType, "image/") {
+			add(name)
+			continue
+		}
+
+		file, ok := files[name]
+		if !ok {
+			continue
+		}
+		refs, err := epubDocumentImages(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading file %s: %v", name, err)
+		}
+		for _, href := range refs {
+			add(resolveEPUBHref(name, href))
+		}
+	}
+
+	if len(images) == 0 && len(pkg.Spine) > 0 {
+		return nil, ErrEPUBNotImages
+	}
+	return images, nil
+}
+
+// readEPUBXML decodes the entry called name from files into v.
+func readEPUBXML(files map[string]*zip.File, name string, v any) error {
+	file, ok := files[name]
+	if !ok {
+		return fmt.Errorf("error reading EPUB: %s not found", name)
+	}
+
+	rc, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("error reading file %s: %v", name, err)
+	}
+	defer rc.Close()
+
+	if err := xml.NewDecoder(rc).Decode(v); err != nil {
+		return fmt.Errorf("error parsing %s: %v", name, err)
+	}
+	return nil
+}
+
+// epubDocumentImages returns the sources of the <img> and SVG <image>
+// elements of an XHTML document, in document order. The document is parsed
+// leniently, as EPUBs often contain HTML entities and unclosed tags.
+func epubDocumentImages(file *zip.File) ([]string, error) {
+	rc, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	decoder := xml.NewDecoder(rc)
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+
+	var refs []string
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return refs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		for _, attr := range start.Attr {
+			switch {
+			case strings.EqualFold(start.Name.Local, "img") && attr.Name.Local == "src",
+				start.Name.Local == "image" && attr.Name.Local == "href":
+				refs = append(refs, attr.Value)
+			}
+		}
+	}
+}
+
+// resolveEPUBHref resolves href, which is relative to the entry called base
+// and may be URL-encoded, to an entry name.
+func resolveEPUBHref(base, href string) string {
+	href, _, _ = strings.Cut(href, "#")
+	if unescaped, err := url.PathUnescape(href); err == nil {
+		href = unescaped
+	}
+	return path.Join(path.Dir(base), href)
+}
@@ -0,0 +1,64 @@
+package cbz
+
+import (
+	"image"
+	"math"
+)
+
+// toGrayscale converts img to 8-bit grayscale using the ITU-R BT.601 luma
+// weights (0.299R + 0.587G + 0.114B).
+func toGrayscale(img *image.RGBA) *image.Gray {
+	bounds := img.Bounds()
+	gray := image.NewGray(bounds)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		src := img.Pix[img.PixOffset(bounds.Min.X, y):]
+		dst := gray.Pix[gray.PixOffset(bounds.Min.X, y):]
+		for x := 0; x < bounds.Dx(); x++ {
+			r, g, b := uint32(src[4*x]), uint32(src[4*x+1]), uint32(src[4*x+2])
+			dst[x] = uint8((299*r + 587*g + 114*b + 500) / 1000)
+		}
+	}
+
+	return gray
+}
+
+// invertImage inverts the color channels of img in place, leaving alpha
+// untouched, and returns it.
+func invertImage(img *image.RGBA) *image.RGBA {
+	bounds := img.Bounds()
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(bounds.Min.X, y):]
+		for x := 0; x < bounds.Dx(); x++ {
+			row[4*x] = 255 - row[4*x]
+			row[4*x+1] = 255 - row[4*x+1]
+			row[4*x+2] = 255 - row[4*x+2]
+		}
+	}
+
+	return img
+}
+
+// adjustImage applies brightness and contrast to the color channels of img in
+// place and returns it. Each channel, normalized to 0-1, becomes
+// clamp((v + brightness) * contrast).
+func adjustImage(img *image.RGBA, brightness, contrast float64) *image.RGBA {
+	var table [256]uint8
+	for i := range table {
+		v := (float64(i)/255 + brightness) * contrast
+		table[i] = uint8(math.Round(math.Max(0, math.Min(1, v)) * 255))
+	}
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(bounds.Min.X, y):]
+		for x := 0; x < bounds.Dx(); x++ {
+			row[4*x] = table[row[4*x]]
+			row[4*x+1] = table[row[4*x+1]]
+			row[4*x+2] = table[row[4*x+2]]
+		}
+	}
+
+	return img
+}
@@ -0,0 +1,41 @@
+package cbz
+
+import (
+	"image"
+	"image/color"
+
+	"golang.org/x/image/draw"
+)
+
+// gridCellSize returns the size of one cell of the grid layout: the first
+// page's width divided among opts.GridColumns columns, and the tallest page
+// once scaled to that width.
+func gridCellSize(pages []image.Image, opts StripOptions) (int, int) {
+	cellWidth := max(1, pages[0].Bounds().Dx()/opts.GridColumns)
+
+	cellHeight := 1
+	for _, img := range pages {
+		bounds := img.Bounds()
+		cellHeight = max(cellHeight, bounds.Dy()*cellWidth/bounds.Dx())
+	}
+	return cellWidth, cellHeight
+}
+
+// gridSize returns the dimensions of the grid layout for pages.
+func gridSize(pages []image.Image, opts StripOptions) (int, int) {
+	cellWidth, cellHeight := gridCellSize(pages, opts)
+	cols := min(opts.GridColumns, len(pages))
+	rows := (len(pages) + opts.GridColumns - 1) / opts.GridColumns
+	return cols*cellWidth + (cols-1)*opts.GapHeight, rows*cellHeight + (rows-1)*opts.GapHeight
+}
+
+// drawGrid scales each page to the cell width and places it in row-major
+// order, top-aligned in its cell. Gaps, cell padding and the unused cells
+// of a partial last row are filled with background.
+func drawGrid(dst *image.RGBA, pages []image.Image, opts StripOptions, background color.Color) {
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+
+	for i, rect := range layoutRects(pages, opts) {
+		draw.CatmullRom.Scale(dst, rect, pages[i], pages[i].Bounds(), draw.Src, nil)
+	}
+}
@@ -0,0 +1,306 @@
+package cbz
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+)
+
+// ArchiveSummary describes the pages of an archive without decoding them.
+type ArchiveSummary struct {
+	PageCount       int      `json:"pageCount"`
+	CommonWidth     int      `json:"commonWidth"`
+	WidthMismatches int      `json:"widthMismatches"`
+	Formats         []string `json:"formats"`
+
+	// FirstFormat is the image format of the first page in reading order.
+	FirstFormat string `json:"firstFormat,omitempty"`
+
+	// FormatCounts counts the pages of each image format, and FailedDecodes
+	// the pages whose headers could not be parsed, which a strip would
+	// skip. Both are read from the image headers, like the rest of the
+	// summary.
+	FormatCounts  map[string]int `json:"formatCounts"`
+	FailedDecodes int            `json:"failedDecodes"`
+}
+
+type pageConfig struct {
+	name   string
+	config image.Config
+	format string
+}
+
+// PageInfo describes a single page of an archive.
+type PageInfo struct {
+	Index    int    `json:"index"`
+	Filename string `json:"filename"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Format   string `json:"format"`
+}
+
+// CreateManifest lists the pages of the archive at cbzFilePath in reading
+// order with their dimensions and format, read from the image headers
+// without decoding any pixels. Pages whose headers cannot be parsed are
+// left out.
+func CreateManifest(cbzFilePath string) ([]PageInfo, error) {
+	pages, _, err := readPageConfigs(cbzFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := make([]PageInfo, len(pages))
+	for i, page := range pages {
+		manifest[i] = PageInfo{
+			Index:    i,
+			Filename: page.name,
+			Width:    page.config.Width,
+			Height:   page.config.Height,
+			Format:   page.format,
+		}
+	}
+	return manifest, nil
+}
+
+// SummarizeArchive reads the image headers of every page in the archive at
+// cbzFilePath. Pages whose headers cannot be parsed are not counted, matching
+// the pages CreateWebtoonStrip would skip. When every entry of a CBZ is an
+// image by extension or ComicInfo.xml, its pages are found in the central
+// directory, as ListPages does, so other entries are never decompressed.
+// Otherwise the other entries may be pages detected by their content, and
+// every entry's header is read as CreateWebtoonStrip does.
+func SummarizeArchive(cbzFilePath string) (ArchiveSummary, error) {
+	readConfigs := readPageConfigs
+	if strings.EqualFold(filepath.Ext(cbzFilePath), ".cbz") {
+		listed, err := pagesListedByName(cbzFilePath)
+		if err != nil {
+			return ArchiveSummary{}, err
+		}
+		if listed {
+			readConfigs = readCBZPageConfigs
+		}
+	}
+	pages, failed, err := readConfigs(cbzFilePath)
+	if err != nil {
+		return ArchiveSummary{}, err
+	}
+
+	summary := ArchiveSummary{
+		PageCount:     len(pages),
+		Formats:       []string{},
+		FormatCounts:  make(map[string]int),
+		FailedDecodes: failed,
+	}
+	if len(pages) > 0 {
+		summary.FirstFormat = pages[0].format
+	}
+	seen := make(map[string]bool)
+	for _, page := range pages {
+		summary.FormatCounts[page.format]++
+		if summary.CommonWidth == 0 {
+			summary.CommonWidth = page.config.Width
+		} else if page.config.Width != summary.CommonWidth {
+			summary.WidthMismatches++
+		}
+
+		if !seen[page.format] {
+			seen[page.format] = true
+			summary.Formats = append(summary.Formats, page.format)
+		}
+	}
+	sort.Strings(summary.Formats)
+
+	return summary, nil
+}
+
+// readPageConfigs reads the image header of every page in the archive at
+// cbzFilePath, returning them in reading order. Pages whose headers cannot be
+// parsed are logged and left out, and their number is returned.
+func readPageConfigs(cbzFilePath string) ([]pageConfig, int, error) {
+	var pages []pageConfig
+	failed := 0
+	err := walkArchive(cbzFilePath, func(name string, r io.Reader) (bool, error) {
+		header, r, err := peekHeader(r)
+		if err != nil {
+			return false, fmt.Errorf("error reading file %s: %v", name, err)
+		}
+		if !isImageEntry(name, header) {
+			return true, nil
+		}
+
+		config, format, err := DecodeImageConfig(r)
+		if err != nil {
+			slog.Error("Error reading image header", "file", name, "error", err)
+			failed++
+			return true, nil
+		}
+
+		pages = append(pages, pageConfig{name: name, config: config, format: format})
+		return true, nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sortPages(cbzFilePath, pages, func(page pageConfig) string { return page.name })
+
+	return pages, failed, nil
+}
+
+// pagesListedByName reports whether every regular entry of the CBZ at
+// cbzPath either has an image extension or is ComicInfo.xml, so that
+// ListPages finds every page without sniffing any content.
+func pagesListedByName(cbzPath string) (bool, error) {
+	reader, err := zip.OpenReader(cbzPath)
+	if err != nil {
+		return false, fmt.Errorf("error opening CBZ file: %v", err)
+	}
+	defer reader.Close()
+
+	for _, file := range reader.File {
+		if !file.FileInfo().IsDir() && !isImageFile(file.Name) && !isComicInfoEntry(file.Name) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// configHeaderLen is how much of an image DecodeImageConfig reads before
+// falling back to the whole image. It covers the header of every supported
+// format short of JPEGs with unusually large metadata and TIFFs whose
+// directory follows the pixels.
+const configHeaderLen = 64 << 10
+
+// readCBZPageConfigs is readPageConfigs for a CBZ, reading the headers of
+// the pages ListPages finds.
+func readCBZPageConfigs(cbzPath string) ([]pageConfig, int, error) {
+	entries, err := ListPages(cbzPath)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	reader, err := zip.OpenReader(cbzPath)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error opening CBZ file: %v", err)
+	}
+	defer reader.Close()
+
+	if err := verifyZip(cbzPath, reader); err != nil {
+		return nil, 0, err
+	}
+
+	files := make(map[string]*zip.File, len(reader.File))
+	for _, file := range reader.File {
+		if name, err := sanitizeEntryName(file.Name); err == nil {
+			files[name] = file
+		}
+	}
+
+	var pages []pageConfig
+	failed := 0
+	for _, entry := range entries {
+		file := files[entry.Name]
+		if file.Flags&0x1 != 0 {
+			return nil, 0, fmt.Errorf("%w: %s", ErrArchiveEncrypted, file.Name)
+		}
+
+		rc, err := openZipEntry(file)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error reading file %s: %v", entry.Name, err)
+		}
+		config, format, err := DecodeImageConfig(rc)
+		rc.Close()
+		if err != nil {
+			slog.Error("Error reading image header", "file", entry.Name, "error", err)
+			failed++
+			continue
+		}
+
+		pages = append(pages, pageConfig{name: entry.Name, config: config, format: format})
+	}
+	return pages, failed, nil
+}
+
+// DecodeImageConfig is the header-only counterpart of DecodeImage, reporting
+// the dimensions of JPEGs as turned upright. Only the first configHeaderLen
+// bytes of r are read unless the header lies beyond them.
+func DecodeImageConfig(r io.Reader) (config image.Config, format string, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			config, format, err = image.Config{}, "", fmt.Errorf("image decoder panicked: %v", p)
+		}
+	}()
+
+	data, err := io.ReadAll(io.LimitReader(r, configHeaderLen))
+	if err != nil {
+		return image.Config{}, "", fmt.Errorf("error reading image data: %v", err)
+	}
+	config, format, err = decodeImageConfig(data)
+	if err != nil && len(data) == configHeaderLen {
+		rest, readErr := io.ReadAll(r)
+		if readErr != nil {
+			return image.Config{}, "", fmt.Errorf("error reading image data: %v", readErr)
+		}
+		data = append(data, rest...)
+		config, format, err = decodeImageConfig(data)
+	}
+	if err != nil {
+		return image.Config{}, "", err
+	}
+	return orientConfig(config, format, data), format, nil
+}
+
+// decodeImageConfig tries each supported decoder on data in turn.
+func decodeImageConfig(data []byte) (image.Config, string, error) {
+	format := sniffFormat(data)
+	if decodeConfig, ok := sniffedConfigDecoders[format]; ok {
+		if config, err := decodeConfig(bytes.NewReader(data)); err == nil {
+			return config, format, nil
+		}
+	}
+
+	if config, err := jpeg.DecodeConfig(bytes.NewReader(data)); err == nil {
+		return config, "jpeg", nil
+	}
+
+	if config, err := png.DecodeConfig(bytes.NewReader(data)); err == nil {
+		return config, "png", nil
+	}
+
+	if config, err := webp.DecodeConfig(bytes.NewReader(data)); err == nil {
+		return config, "webp", nil
+	}
+
+	if config, err := gif.DecodeConfig(bytes.NewReader(data)); err == nil {
+		return config, "gif", nil
+	}
+
+	if config, err := bmp.DecodeConfig(bytes.NewReader(data)); err == nil {
+		return config, "bmp", nil
+	}
+
+	if config, err := tiff.DecodeConfig(bytes.NewReader(data)); err == nil {
+		return config, "tiff", nil
+	}
+
+	for _, dec := range optionalDecoders {
+		if config, err := dec.decodeConfig(bytes.NewReader(data)); err == nil {
+			return config, dec.format, nil
+		}
+	}
+
+	return image.Config{}, "", fmt.Errorf("unsupported image format")
+}
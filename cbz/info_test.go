@@ -0,0 +1,86 @@
+package cbz
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func TestDecodeImageConfigReadsHeaderOnly(t *testing.T) {
+	// Noise does not compress, so the PNG is far larger than its header.
+	img := image.NewGray(image.Rect(0, 0, 600, 600))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	size := buf.Len()
+
+	r := &countingReader{r: &buf}
+	config, format, err := DecodeImageConfig(r)
+	if err != nil {
+		t.Fatalf("DecodeImageConfig: %v", err)
+	}
+	if format != "png" || config.Width != 600 || config.Height != 600 {
+		t.Errorf("DecodeImageConfig = %dx%d %s, want 600x600 png", config.Width, config.Height, format)
+	}
+	if r.n > configHeaderLen {
+		t.Errorf("read %d of %d bytes, want at most %d", r.n, size, configHeaderLen)
+	}
+}
+
+func TestSummarizeArchive(t *testing.T) {
+	summary, err := SummarizeArchive(filepath.Join(fixtureDir, "mixed.cbz"))
+	if err != nil {
+		t.Fatalf("SummarizeArchive: %v", err)
+	}
+	if summary.PageCount != 3 || summary.CommonWidth != 120 || summary.WidthMismatches != 0 {
+		t.Errorf("summary = %+v, want 3 pages 120 wide", summary)
+	}
+	if summary.FirstFormat != "jpeg" || summary.FormatCounts["jpeg"] != 2 || summary.FormatCounts["png"] != 1 {
+		t.Errorf("formats = %s first, %v, want jpeg first, 2 jpeg and 1 png", summary.FirstFormat, summary.FormatCounts)
+	}
+
+	summary, err = SummarizeArchive(filepath.Join(fixtureDir, "noimages.cbz"))
+	if err != nil {
+		t.Fatalf("SummarizeArchive: %v", err)
+	}
+	if summary.PageCount != 0 {
+		t.Errorf("PageCount = %d for an archive without images, want 0", summary.PageCount)
+	}
+}
+
+func TestListPages(t *testing.T) {
+	path := writeTestArchive(t, "list.cbz",
+		archiveEntry{"page10.png", pngPage(4, 4)},
+		archiveEntry{"ComicInfo.xml", []byte("<ComicInfo/>")},
+		archiveEntry{"page2.png", pngPage(4, 4)},
+	)
+
+	pages, err := ListPages(path)
+	if err != nil {
+		t.Fatalf("ListPages: %v", err)
+	}
+	if len(pages) != 2 || pages[0].Name != "page2.png" || pages[1].Name != "page10.png" {
+		t.Fatalf("ListPages = %+v, want page2.png then page10.png", pages)
+	}
+	if pages[0].UncompressedSize == 0 {
+		t.Error("UncompressedSize not read from the central directory")
+	}
+}
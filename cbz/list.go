@@ -0,0 +1,65 @@
+package cbz
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ArchiveInfo describes an archive available for conversion.
+type ArchiveInfo struct {
+	Name  string    `json:"name"`
+	Size  int64     `json:"size"`
+	Mtime time.Time `json:"mtime"`
+}
+
+// ListArchives returns the supported archives under dir, descending at most
+// maxDepth levels into subdirectories. Names are relative to dir, use forward
+// slashes and are sorted in natural order.
+func ListArchives(dir string, maxDepth int) ([]ArchiveInfo, error) {
+	files := []ArchiveInfo{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if rel != "." && strings.Count(filepath.ToSlash(rel), "/") >= maxDepth {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !d.Type().IsRegular() || !IsArchiveFile(d.Name()) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		files = append(files, ArchiveInfo{
+			Name:  filepath.ToSlash(rel),
+			Size:  info.Size(),
+			Mtime: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return naturalLess(files[i].Name, files[j].Name)
+	})
+
+	return files, nil
+}
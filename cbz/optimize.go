@@ -0,0 +1,147 @@
+package cbz
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+)
+
+// optimizeExtensions maps the formats OptimizeCBZ can re-encode to to the
+// extension given to re-encoded pages.
+var optimizeExtensions = map[string]string{
+	"jpeg": ".jpg",
+	"webp": ".webp",
+}
+
+// OptimizeOptions controls how OptimizeCBZ re-encodes pages.
+type OptimizeOptions struct {
+	// Format is the format pages are re-encoded as, "jpeg" or "webp".
+	Format string
+
+	// Quality is the JPEG or WebP quality from 1 to 100.
+	Quality int
+
+	// MaxInputBytes rejects archives larger than this many bytes with
+	// ErrFileTooLarge, as StripOptions.MaxInputBytes does. Zero means no
+	// limit.
+	MaxInputBytes int64
+}
+
+// OptimizeCBZ reads the archive at src and returns a new CBZ in which every
+// page has been re-encoded with opts, renamed to the new format's extension.
+// Other entries, such as ComicInfo.xml, and pages that fail to decode are
+// copied unchanged. Entries are written in natural filename order; EPUB
+// pages are numbered instead so the CBZ keeps their reading order. The
+// archive is read before OptimizeCBZ returns; pages are re-encoded as the
+// returned reader is consumed, and any error doing so is returned from Read.
+// The reader is also an io.Closer; closing it early stops the re-encoding.
+func OptimizeCBZ(src string, opts OptimizeOptions) (io.Reader, error) {
+	ext, ok := optimizeExtensions[opts.Format]
+	if !ok {
+		return nil, fmt.Errorf("unsupported optimize format: %s", opts.Format)
+	}
+
+	if opts.MaxInputBytes > 0 {
+		info, err := os.Stat(src)
+		if err != nil {
+			return nil, err
+		}
+		if info.Size() > opts.MaxInputBytes {
+			return nil, fmt.Errorf("%w: %d bytes exceeds the %d byte limit", ErrFileTooLarge, info.Size(), opts.MaxInputBytes)
+		}
+	}
+
+	entries, _, err := openArchiveReader(src)
+	if err != nil {
+		return nil, err
+	}
+	sortPages(src, entries, entryName)
+	if strings.EqualFold(path.Ext(src), ".epub") {
+		for i := range entries {
+			entries[i].Name = fmt.Sprintf("%03d_%s", i+1, path.Base(entries[i].Name))
+		}
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeOptimizedZip(pw, entries, ext, opts))
+	}()
+	return pr, nil
+}
+
+// writeOptimizedZip writes entries to w as a zip, re-encoding image entries
+// with opts and giving them the extension ext.
+func writeOptimizedZip(w io.Writer, entries []namedReadCloser, ext string, opts OptimizeOptions) error {
+	// A renamed page keeps its original name if the new one belongs to
+	// another entry, as when 001.png and 001.jpg would both become 001.jpg.
+	taken := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		taken[entry.Name] = true
+	}
+
+	zw := zip.NewWriter(w)
+	for _, entry := range entries {
+		header, err := entry.peekHeader()
+		if err != nil {
+			return fmt.Errorf("error reading file %s: %v", entry.Name, err)
+		}
+
+		if !isImageEntry(entry.Name, header) {
+			err = copyZipEntry(zw, entry.Name, entry, zip.Deflate)
+			entry.Close()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		data, err := io.ReadAll(entry)
+		entry.Close()
+		if err != nil {
+			return fmt.Errorf("error reading file %s: %v", entry.Name, err)
+		}
+
+		img, _, err := DecodeImage(bytes.NewReader(data))
+		if err != nil {
+			slog.Warn("Copying page that failed to decode", "file", entry.Name, "error", err)
+			if err := copyZipEntry(zw, entry.Name, bytes.NewReader(data), zip.Store); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Compressed images do not shrink further, so they are stored.
+		name := strings.TrimSuffix(entry.Name, path.Ext(entry.Name)) + ext
+		if name != entry.Name {
+			if taken[name] {
+				name = entry.Name
+			}
+			taken[name] = true
+		}
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			return fmt.Errorf("error writing %s: %v", name, err)
+		}
+		if err := EncodeWithOptions(fw, img, opts.Format, EncodeOptions{Quality: opts.Quality}); err != nil {
+			return fmt.Errorf("error encoding %s: %v", name, err)
+		}
+	}
+	return zw.Close()
+}
+
+// copyZipEntry writes the contents of r to zw as an entry called name.
+func copyZipEntry(zw *zip.Writer, name string, r io.Reader, method uint16) error {
+	fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+	if err != nil {
+		return fmt.Errorf("error writing %s: %v", name, err)
+	}
+	if _, err := io.Copy(fw, r); err != nil {
+		return fmt.Errorf("error writing %s: %v", name, err)
+	}
+	return nil
+}
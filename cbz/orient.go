@@ -0,0 +1,112 @@
+package cbz
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// decodePage is DecodeImage, only turning JPEGs upright when autoRotate is
+// set. When maxPixels is positive, images whose header claims more pixels
+// are rejected with ErrOutputTooLarge before any pixels are decoded.
+func decodePage(r io.Reader, autoRotate bool, maxPixels int) (img image.Image, format string, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading image data: %v", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			img, format, err = nil, "", fmt.Errorf("image decoder panicked: %v", p)
+		}
+	}()
+
+	if maxPixels > 0 {
+		if config, _, err := decodeImageConfig(data); err == nil {
+			if pixels := int64(config.Width) * int64(config.Height); pixels > int64(maxPixels) {
+				return nil, "", fmt.Errorf("%w: page of %dx%d exceeds the %d pixel limit", ErrOutputTooLarge, config.Width, config.Height, maxPixels)
+			}
+		}
+	}
+
+	img, format, err = decodeImage(data)
+	if err != nil || !autoRotate || format != "jpeg" {
+		return img, format, err
+	}
+	return orientImage(img, exifOrientation(data)), format, nil
+}
+
+// orientConfig returns the dimensions of the image config describes once
+// DecodeImage has turned it upright, swapping them for JPEGs in data whose
+// EXIF orientation is a quarter turn.
+func orientConfig(config image.Config, format string, data []byte) image.Config {
+	if format == "jpeg" && exifOrientation(data) >= 5 {
+		config.Width, config.Height = config.Height, config.Width
+	}
+	return config
+}
+
+// exifOrientation returns the EXIF orientation of the JPEG in data, from 1
+// (upright) to 8, or 1 if it has none.
+func exifOrientation(data []byte) int {
+	x, err := exif.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 1
+	}
+	tag, err := x.Get(exif.Orientation)
+	if err != nil {
+		return 1
+	}
+	orientation, err := tag.Int(0)
+	if err != nil || orientation < 1 || orientation > 8 {
+		return 1
+	}
+	return orientation
+}
+
+// orientImage returns img transformed so that an image stored with the given
+// EXIF orientation is displayed upright.
+func orientImage(img image.Image, orientation int) image.Image {
+	if orientation == 1 {
+		return img
+	}
+
+	src := toRGBA(img)
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+
+	// Orientations 5 to 8 swap the axes. source maps each destination pixel
+	// to the source pixel it is copied from.
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		dstW, dstH = h, w
+	}
+	var source func(x, y int) (int, int)
+	switch orientation {
+	case 2: // Mirrored horizontally
+		source = func(x, y int) (int, int) { return w - 1 - x, y }
+	case 3: // Rotated 180°
+		source = func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }
+	case 4: // Mirrored vertically
+		source = func(x, y int) (int, int) { return x, h - 1 - y }
+	case 5: // Transposed
+		source = func(x, y int) (int, int) { return y, x }
+	case 6: // Rotated 90° clockwise
+		source = func(x, y int) (int, int) { return y, h - 1 - x }
+	case 7: // Transversed
+		source = func(x, y int) (int, int) { return w - 1 - y, h - 1 - x }
+	case 8: // Rotated 90° counterclockwise
+		source = func(x, y int) (int, int) { return w - 1 - y, x }
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		for x := 0; x < dstW; x++ {
+			sx, sy := source(x, y)
+			copy(dst.Pix[dst.PixOffset(x, y):][:4], src.Pix[src.PixOffset(sx, sy):][:4])
+		}
+	}
+	return dst
+}
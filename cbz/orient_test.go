@@ -0,0 +1,87 @@
+package cbz
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"testing"
+)
+
+// withOrientation inserts an EXIF APP1 segment holding the given orientation
+// tag right after the start-of-image marker of the JPEG in data.
+func withOrientation(data []byte, orientation uint16) []byte {
+	var tiff bytes.Buffer
+	tiff.WriteString("II*\x00")
+	binary.Write(&tiff, binary.LittleEndian, uint32(8)) // offset of IFD0
+	binary.Write(&tiff, binary.LittleEndian, uint16(1)) // one entry
+	binary.Write(&tiff, binary.LittleEndian, uint16(0x0112))
+	binary.Write(&tiff, binary.LittleEndian, uint16(3)) // SHORT
+	binary.Write(&tiff, binary.LittleEndian, uint32(1))
+	binary.Write(&tiff, binary.LittleEndian, orientation)
+	binary.Write(&tiff, binary.LittleEndian, uint16(0))
+	binary.Write(&tiff, binary.LittleEndian, uint32(0)) // no IFD1
+
+	payload := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+func TestOrientation6(t *testing.T) {
+	page := withOrientation(jpegPage(120, 200), 6)
+	upright := image.Pt(200, 120)
+	path := writeTestArchive(t, "rotated.cbz", archiveEntry{"001.jpg", page})
+
+	img, _, err := DecodeImage(bytes.NewReader(page))
+	if err != nil {
+		t.Fatalf("DecodeImage: %v", err)
+	}
+	if got := img.Bounds().Size(); got != upright {
+		t.Errorf("DecodeImage size = %v, want %v", got, upright)
+	}
+
+	img, _, err = ExtractPage(path, 0)
+	if err != nil {
+		t.Fatalf("ExtractPage: %v", err)
+	}
+	if got := img.Bounds().Size(); got != upright {
+		t.Errorf("ExtractPage size = %v, want %v", got, upright)
+	}
+
+	manifest, err := CreateManifest(path)
+	if err != nil {
+		t.Fatalf("CreateManifest: %v", err)
+	}
+	if got := image.Pt(manifest[0].Width, manifest[0].Height); got != upright {
+		t.Errorf("manifest size = %v, want %v", got, upright)
+	}
+
+	summary, err := SummarizeArchive(path)
+	if err != nil {
+		t.Fatalf("SummarizeArchive: %v", err)
+	}
+	if summary.CommonWidth != upright.X {
+		t.Errorf("summary width = %d, want %d", summary.CommonWidth, upright.X)
+	}
+
+	strip, _, err := CreateWebtoonStrip(context.Background(), path, StripOptions{AutoRotate: true})
+	if err != nil {
+		t.Fatalf("CreateWebtoonStrip: %v", err)
+	}
+	if got := strip.Bounds().Size(); got != upright {
+		t.Errorf("strip size = %v, want %v", got, upright)
+	}
+
+	strip, _, err = CreateWebtoonStrip(context.Background(), path, StripOptions{})
+	if err != nil {
+		t.Fatalf("CreateWebtoonStrip: %v", err)
+	}
+	if got := strip.Bounds().Size(); got != image.Pt(120, 200) {
+		t.Errorf("strip size without AutoRotate = %v, want (120,200)", got)
+	}
+}
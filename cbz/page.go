@@ -0,0 +1,151 @@
+package cbz
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrPageOutOfRange is returned by ExtractPage when the index is past the
+// last page in the archive.
+var ErrPageOutOfRange = errors.New("page index out of range")
+
+// ErrInvalidPageRange is returned by CreateWebtoonStrip when
+// StripOptions.SelectRange does not fit the archive.
+var ErrInvalidPageRange = errors.New("invalid page range")
+
+// pageNames lists the image entries of the archive at path in reading order,
+// decompressing only the few bytes needed to sniff each entry's format.
+func pageNames(path string) ([]string, error) {
+	var pages []string
+	err := walkArchive(path, func(name string, r io.Reader) (bool, error) {
+		header, _, err := peekHeader(r)
+		if err != nil {
+			return false, fmt.Errorf("error reading file %s: %v", name, err)
+		}
+		if isImageEntry(name, header) {
+			pages = append(pages, name)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortPages(path, pages, func(name string) string { return name })
+
+	return pages, nil
+}
+
+// PageEntry describes a page of a zip archive as recorded in its central
+// directory.
+type PageEntry struct {
+	Name             string `json:"name"`
+	CompressedSize   uint64 `json:"compressedSize"`
+	UncompressedSize uint64 `json:"uncompressedSize"`
+}
+
+// ListPages lists the image entries of the CBZ at cbzPath in reading order,
+// chosen by file extension. Only the zip central directory is read, so no
+// entry is decompressed and the cost does not grow with the size of the
+// images. Other archive formats have no central directory and are not
+// supported.
+func ListPages(cbzPath string) ([]PageEntry, error) {
+	if !strings.EqualFold(filepath.Ext(cbzPath), ".cbz") {
+		return nil, fmt.Errorf("unsupported archive format for page listing: %s", filepath.Ext(cbzPath))
+	}
+
+	reader, err := zip.OpenReader(cbzPath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening CBZ file: %v", err)
+	}
+	defer reader.Close()
+
+	var pages []PageEntry
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() || !isImageFile(file.Name) {
+			continue
+		}
+
+		name, err := sanitizeEntryName(file.Name)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, PageEntry{
+			Name:             name,
+			CompressedSize:   file.CompressedSize64,
+			UncompressedSize: file.UncompressedSize64,
+		})
+	}
+
+	sort.Slice(pages, func(i, j int) bool {
+		return naturalLess(pages[i].Name, pages[j].Name)
+	})
+
+	return pages, nil
+}
+
+// ExtractPage decodes the page at the 0-based index from the archive at
+// cbzFilePath, returning the image and the format it was decoded as. Only the
+// requested entry is decompressed.
+func ExtractPage(cbzFilePath string, index int) (image.Image, string, error) {
+	pages, err := pageNames(cbzFilePath)
+	if err != nil {
+		return nil, "", err
+	}
+	if index < 0 || index >= len(pages) {
+		return nil, "", ErrPageOutOfRange
+	}
+
+	entry, err := openArchiveEntry(cbzFilePath, pages[index])
+	if err != nil {
+		return nil, "", err
+	}
+	defer entry.Close()
+
+	return DecodeImage(entry)
+}
+
+// ExtractRawPage returns the original bytes of the page at the 0-based index
+// from the archive at cbzFilePath, along with its entry name, without
+// decoding it.
+func ExtractRawPage(cbzFilePath string, index int) (string, []byte, error) {
+	pages, err := pageNames(cbzFilePath)
+	if err != nil {
+		return "", nil, err
+	}
+	if index < 0 || index >= len(pages) {
+		return "", nil, ErrPageOutOfRange
+	}
+
+	entry, err := openArchiveEntry(cbzFilePath, pages[index])
+	if err != nil {
+		return "", nil, err
+	}
+	defer entry.Close()
+
+	data, err := io.ReadAll(entry)
+	if err != nil {
+		return "", nil, fmt.Errorf("error reading file %s: %v", entry.Name, err)
+	}
+	return entry.Name, data, nil
+}
+
+// CreateThumbnail extracts the page at index and scales it down to at most
+// maxWidth pixels wide, preserving its aspect ratio.
+func CreateThumbnail(cbzFilePath string, index int, maxWidth int) (image.Image, error) {
+	img, _, err := ExtractPage(cbzFilePath, index)
+	if err != nil {
+		return nil, err
+	}
+
+	if img.Bounds().Dx() > maxWidth {
+		img = scaleToWidth(img, maxWidth)
+	}
+	return img, nil
+}
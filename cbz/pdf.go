@@ -0,0 +1,37 @@
+package cbz
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// encodePDF writes img to w as a single-page PDF sized to the image, one
+// point per pixel, with the image embedded as a JPEG at the given quality.
+func encodePDF(w io.Writer, img image.Image, quality int) error {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return fmt.Errorf("error encoding PDF image: %v", err)
+	}
+
+	bounds := img.Bounds()
+	width, height := float64(bounds.Dx()), float64(bounds.Dy())
+
+	pdf := gofpdf.NewCustom(&gofpdf.InitType{
+		UnitStr: "pt",
+		Size:    gofpdf.SizeType{Wd: width, Ht: height},
+	})
+	pdf.SetMargins(0, 0, 0)
+	pdf.SetAutoPageBreak(false, 0)
+	pdf.AddPage()
+
+	options := gofpdf.ImageOptions{ImageType: "JPG"}
+	pdf.RegisterImageOptionsReader("strip", options, &buf)
+	pdf.ImageOptions("strip", 0, 0, width, height, false, options, 0, "")
+
+	return pdf.Output(w)
+}
@@ -0,0 +1,403 @@
+package cbz
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"hash/adler32"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// parallelPNGMinRows is the height from which EncodeWithOptions compresses
+// PNGs in parallel bands rather than with image/png.
+const parallelPNGMinRows = 2048
+
+// pngBandRows is the number of rows each goroutine filters and compresses.
+const pngBandRows = 256
+
+// pngSignature starts every PNG file.
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// pngRows gives row-by-row access to the raw pixels of an image the banded
+// encoder supports: an opaque *image.RGBA or *fileImage, written as 8-bit
+// RGB, or an *image.Gray, written as 8-bit grayscale.
+type pngRows struct {
+	width, height int
+	gray          bool
+
+	// readRow copies row y, relative to the top of the image, into dst
+	// as 4 bytes per pixel RGBA, or 1 byte per pixel for grayscale. It is
+	// called from several goroutines at once.
+	readRow func(y int, dst []byte) error
+}
+
+// newPNGRows returns the rows of img, or false if img is not an image the
+// banded encoder supports.
+func newPNGRows(img image.Image) (pngRows, bool) {
+	bounds := img.Bounds()
+	rows := pngRows{width: bounds.Dx(), height: bounds.Dy()}
+
+	switch m := img.(type) {
+	case *image.RGBA:
+		if !m.Opaque() {
+			return rows, false
+		}
+		rows.readRow = func(y int, dst []byte) error {
+			copy(dst, m.Pix[m.PixOffset(bounds.Min.X, bounds.Min.Y+y):])
+			return nil
+		}
+	case *image.Gray:
+		rows.gray = true
+		rows.readRow = func(y int, dst []byte) error {
+			copy(dst, m.Pix[m.PixOffset(bounds.Min.X, bounds.Min.Y+y):])
+			return nil
+		}
+	case *fileImage:
+		if !m.Opaque() {
+			return rows, false
+		}
+		rows.gray = m.gray
+		rows.readRow = m.readRow
+	default:
+		return rows, false
+	}
+	return rows, rows.width > 0
+}
+
+// pngBand is the compressed image data of one band of rows.
+type pngBand struct {
+	data   []byte
+	adler  uint32
+	length int64
+	err    error
+	done   chan struct{}
+}
+
+// encodePNGBands writes rows to w as a PNG, splitting the image into bands
+// of pngBandRows rows that are filtered and compressed concurrently. Each
+// band is a run of raw deflate blocks ended with a sync flush, so the bands
+// concatenate into the single zlib stream the IDAT chunks must hold (RFC
+// 2083, section 10.1); the stream's Adler-32 is combined from the bands'.
+func encodePNGBands(w io.Writer, rows pngRows, level png.CompressionLevel) error {
+	bands := make([]*pngBand, (rows.height+pngBandRows-1)/pngBandRows)
+	for i := range bands {
+		bands[i] = &pngBand{done: make(chan struct{})}
+	}
+
+	jobs := make(chan int)
+	go func() {
+		for i := range bands {
+			jobs <- i
+		}
+		close(jobs)
+	}()
+	var wg sync.WaitGroup
+	for range min(runtime.GOMAXPROCS(0), len(bands)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				compressPNGBand(bands[i], rows, i, i == len(bands)-1, flateLevel(level))
+				close(bands[i].done)
+			}
+		}()
+	}
+	// Drain the remaining bands if writing fails part way.
+	defer wg.Wait()
+
+	colorType := byte(2)
+	if rows.gray {
+		colorType = 0
+	}
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(rows.width))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(rows.height))
+	ihdr[8] = 8 // Bit depth
+	ihdr[9] = colorType
+	if _, err := io.WriteString(w, pngSignature); err != nil {
+		return err
+	}
+	if err := writePNGChunk(w, "IHDR", ihdr); err != nil {
+		return err
+	}
+
+	adler := uint32(1)
+	for i, band := range bands {
+		<-band.done
+		if band.err != nil {
+			return band.err
+		}
+		adler = adler32Combine(adler, band.adler, band.length)
+
+		data := band.data
+		if i == 0 {
+			data = append(zlibHeader(flateLevel(level)), data...)
+		}
+		if i == len(bands)-1 {
+			data = binary.BigEndian.AppendUint32(data, adler)
+		}
+		if err := writePNGChunk(w, "IDAT", data); err != nil {
+			return err
+		}
+	}
+	return writePNGChunk(w, "IEND", nil)
+}
+
+// compressPNGBand filters and deflates band index of rows into band. The
+// last band ends the deflate stream; the others end with a sync flush so
+// the next band can follow.
+func compressPNGBand(band *pngBand, rows pngRows, index int, last bool, level int) {
+	srcBpp, bpp := 4, 3
+	if rows.gray {
+		srcBpp, bpp = 1, 1
+	}
+
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, level)
+	if err != nil {
+		band.err = err
+		return
+	}
+	hash := adler32.New()
+	out := io.MultiWriter(fw, hash)
+
+	src := make([]byte, rows.width*srcBpp)
+	prev := make([]byte, rows.width*bpp)
+	cur := make([]byte, rows.width*bpp)
+	var filtered [5][]byte
+	for i := range filtered {
+		filtered[i] = make([]byte, 1+rows.width*bpp)
+		filtered[i][0] = byte(i)
+	}
+
+	start := index * pngBandRows
+	end := min(start+pngBandRows, rows.height)
+	if start > 0 {
+		if band.err = rows.readRow(start-1, src); band.err != nil {
+			return
+		}
+		packPNGRow(prev, src, rows.gray)
+	}
+	for y := start; y < end; y++ {
+		if band.err = rows.readRow(y, src); band.err != nil {
+			return
+		}
+		packPNGRow(cur, src, rows.gray)
+
+		// Like image/png, skip filtering when not compressing.
+		row := filtered[0]
+		if level == flate.NoCompression {
+			copy(row[1:], cur)
+		} else {
+			row = filterPNGRow(&filtered, cur, prev, bpp)
+		}
+		if _, band.err = out.Write(row); band.err != nil {
+			return
+		}
+		band.length += int64(len(row))
+		prev, cur = cur, prev
+	}
+
+	if last {
+		band.err = fw.Close()
+	} else {
+		band.err = fw.Flush()
+	}
+	band.data = buf.Bytes()
+	band.adler = hash.Sum32()
+}
+
+// packPNGRow copies a row of source pixels into dst as PNG samples,
+// dropping the alpha channel of opaque RGBA rows.
+func packPNGRow(dst, src []byte, gray bool) {
+	if gray {
+		copy(dst, src)
+		return
+	}
+	for i, j := 0, 0; i < len(src); i, j = i+4, j+3 {
+		dst[j], dst[j+1], dst[j+2] = src[i], src[i+1], src[i+2]
+	}
+}
+
+// filterPNGRow applies the PNG filters to cur, given the row above it, and
+// returns the filtered row, led by its filter type, whose bytes have the
+// smallest sum of absolute values. This is the heuristic image/png uses;
+// like it, each filter stops early once it cannot beat the best so far.
+func filterPNGRow(filtered *[5][]byte, cur, prev []byte, bpp int) []byte {
+	n := len(cur)
+
+	// Up.
+	up := filtered[2][1:]
+	sum := 0
+	for i := range n {
+		up[i] = cur[i] - prev[i]
+		sum += abs8(up[i])
+	}
+	best, bestSum := 2, sum
+
+	// Paeth.
+	paeth := filtered[4][1:]
+	sum = 0
+	for i := range bpp {
+		paeth[i] = cur[i] - prev[i]
+		sum += abs8(paeth[i])
+	}
+	for i := bpp; i < n && sum < bestSum; i++ {
+		paeth[i] = cur[i] - paethPredictor(cur[i-bpp], prev[i], prev[i-bpp])
+		sum += abs8(paeth[i])
+	}
+	if sum < bestSum {
+		best, bestSum = 4, sum
+	}
+
+	// None.
+	sum = 0
+	for i := 0; i < n && sum < bestSum; i++ {
+		sum += abs8(cur[i])
+	}
+	if sum < bestSum {
+		copy(filtered[0][1:], cur)
+		best, bestSum = 0, sum
+	}
+
+	// Sub.
+	sub := filtered[1][1:]
+	sum = 0
+	for i := range bpp {
+		sub[i] = cur[i]
+		sum += abs8(sub[i])
+	}
+	for i := bpp; i < n && sum < bestSum; i++ {
+		sub[i] = cur[i] - cur[i-bpp]
+		sum += abs8(sub[i])
+	}
+	if sum < bestSum {
+		best, bestSum = 1, sum
+	}
+
+	// Average.
+	avg := filtered[3][1:]
+	sum = 0
+	for i := range bpp {
+		avg[i] = cur[i] - prev[i]/2
+		sum += abs8(avg[i])
+	}
+	for i := bpp; i < n && sum < bestSum; i++ {
+		avg[i] = cur[i] - uint8((int(cur[i-bpp])+int(prev[i]))/2)
+		sum += abs8(avg[i])
+	}
+	if sum < bestSum {
+		best = 3
+	}
+
+	return filtered[best]
+}
+
+// paethPredictor implements the Paeth filter's predictor function.
+func paethPredictor(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := absInt(p-int(a)), absInt(p-int(b)), absInt(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+// abs8 returns the absolute value of b read as a signed byte.
+func abs8(b byte) int {
+	return absInt(int(int8(b)))
+}
+
+func absInt(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// flateLevel maps a png.CompressionLevel to a compress/flate level, as
+// image/png does.
+func flateLevel(level png.CompressionLevel) int {
+	switch level {
+	case png.NoCompression:
+		return flate.NoCompression
+	case png.BestSpeed:
+		return flate.BestSpeed
+	case png.BestCompression:
+		return flate.BestCompression
+	default:
+		return flate.DefaultCompression
+	}
+}
+
+// zlibHeader returns the two-byte zlib header (RFC 1950) for a deflate
+// stream compressed at level, as compress/zlib writes it.
+func zlibHeader(level int) []byte {
+	header := []byte{0x78, 0}
+	switch level {
+	case -2, 0, 1:
+		header[1] = 0 << 6
+	case 2, 3, 4, 5:
+		header[1] = 1 << 6
+	case 6, -1:
+		header[1] = 2 << 6
+	default:
+		header[1] = 3 << 6
+	}
+	header[1] += 31 - byte((uint16(header[0])<<8|uint16(header[1]))%31)
+	return header
+}
+
+// adler32Combine returns the Adler-32 of two byte sequences concatenated,
+// given the checksum of each and the length of the second, as zlib's
+// adler32_combine does.
+func adler32Combine(adler1, adler2 uint32, length2 int64) uint32 {
+	const base = 65521
+	rem := uint32(length2 % base)
+	sum1 := adler1 & 0xffff
+	sum2 := rem * sum1 % base
+	sum1 += (adler2 & 0xffff) + base - 1
+	sum2 += (adler1 >> 16) + (adler2 >> 16) + base - rem
+	if sum1 >= base {
+		sum1 -= base
+	}
+	if sum1 >= base {
+		sum1 -= base
+	}
+	if sum2 >= base<<1 {
+		sum2 -= base << 1
+	}
+	if sum2 >= base {
+		sum2 -= base
+	}
+	return sum1 | sum2<<16
+}
+
+// writePNGChunk writes a PNG chunk: its length, type, data and the CRC-32 of
+// type and data.
+func writePNGChunk(w io.Writer, chunkType string, data []byte) error {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	copy(header[4:], chunkType)
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	_, err := w.Write(binary.BigEndian.AppendUint32(nil, crc.Sum32()))
+	return err
+}
@@ -0,0 +1,85 @@
+package cbz
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"testing"
+)
+
+func TestEncodePNGBands(t *testing.T) {
+	rgba := testPage(333, 3*pngBandRows+17)
+	gray := image.NewGray(rgba.Bounds())
+	for i := range gray.Pix {
+		gray.Pix[i] = rgba.Pix[i*4]
+	}
+	sub := rgba.SubImage(image.Rect(5, 7, 300, rgba.Bounds().Dy()-9))
+
+	for _, img := range []image.Image{rgba, gray, sub} {
+		for _, level := range []png.CompressionLevel{png.DefaultCompression, png.NoCompression, png.BestSpeed, png.BestCompression} {
+			rows, ok := newPNGRows(img)
+			if !ok {
+				t.Fatalf("newPNGRows(%T) = false", img)
+			}
+			var buf bytes.Buffer
+			if err := encodePNGBands(&buf, rows, level); err != nil {
+				t.Fatalf("encodePNGBands: %v", err)
+			}
+			got, err := png.Decode(&buf)
+			if err != nil {
+				t.Fatalf("level %d: png.Decode: %v", level, err)
+			}
+
+			bounds := img.Bounds()
+			if got.Bounds().Size() != bounds.Size() {
+				t.Fatalf("decoded size = %v, want %v", got.Bounds().Size(), bounds.Size())
+			}
+			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+				for x := bounds.Min.X; x < bounds.Max.X; x++ {
+					if !sameColor(img.At(x, y), got.At(x-bounds.Min.X, y-bounds.Min.Y)) {
+						t.Fatalf("%T level %d: pixel (%d,%d) differs", img, level, x, y)
+					}
+				}
+			}
+		}
+	}
+}
+
+// sameColor reports whether a and b are the same color.
+func sameColor(a, b color.Color) bool {
+	r1, g1, b1, a1 := a.RGBA()
+	r2, g2, b2, a2 := b.RGBA()
+	return r1 == r2 && g1 == g2 && b1 == b2 && a1 == a2
+}
+
+// benchmarkStripHeight is the height of the strip the PNG benchmarks encode,
+// about eight webtoon pages.
+const benchmarkStripHeight = 10000
+
+func BenchmarkEncodePNGSerial(b *testing.B) {
+	img := testPage(benchmarkPageWidth, benchmarkStripHeight)
+	encoder := png.Encoder{CompressionLevel: png.DefaultCompression}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(img.Pix)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := encoder.Encode(io.Discard, img); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodePNGBanded(b *testing.B) {
+	img := testPage(benchmarkPageWidth, benchmarkStripHeight)
+	rows, _ := newPNGRows(img)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(img.Pix)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := encodePNGBands(io.Discard, rows, png.DefaultCompression); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package cbz
+
+import (
+	"image"
+	"runtime"
+	"sync"
+)
+
+// maxPooledStripBytes is the largest strip canvas kept for reuse. Bigger
+// strips are rare enough that holding on to their memory costs more than
+// allocating it again.
+const maxPooledStripBytes = 64 << 20
+
+// stripPool holds the *image.RGBA canvases of released strips.
+var stripPool sync.Pool
+
+// newStripCanvas returns an RGBA image with bounds r, reusing the pixel
+// buffer of a released strip when one is large enough.
+func newStripCanvas(r image.Rectangle) *image.RGBA {
+	n := 4 * r.Dx() * r.Dy()
+	if n > maxPooledStripBytes {
+		return image.NewRGBA(r)
+	}
+
+	img, ok := stripPool.Get().(*image.RGBA)
+	if !ok {
+		return image.NewRGBA(r)
+	}
+	if cap(img.Pix) < n {
+		img.Pix = make([]uint8, n)
+	}
+	img.Pix = img.Pix[:n]
+	img.Stride = 4 * r.Dx()
+	img.Rect = r
+	return img
+}
+
+// ReleaseStrip hands the pixel buffer of a strip returned by CompositeStrip
+// or CreateWebtoonStrip back for later strips to reuse, which saves an
+// allocation the size of the strip on every call. The caller must not use
+// img afterwards, so strips that are still referenced, such as cached ones,
+// must not be released. Strips composed on disk have their temporary file
+// closed; other strips are ignored.
+func ReleaseStrip(img image.Image) {
+	if file, ok := img.(*fileImage); ok {
+		runtime.SetFinalizer(file, nil)
+		file.close()
+		return
+	}
+	rgba, ok := img.(*image.RGBA)
+	if !ok || cap(rgba.Pix) > maxPooledStripBytes {
+		return
+	}
+	// Every strip repaints its whole canvas, but clearing it keeps one
+	// request's pages from ever showing up in another's.
+	clear(rgba.Pix)
+	stripPool.Put(rgba)
+}
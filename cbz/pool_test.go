@@ -0,0 +1,84 @@
+package cbz
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"os"
+	"testing"
+)
+
+func TestReleasedCanvasIsCleared(t *testing.T) {
+	strip := CompositeStrip([]image.Image{testPage(64, 48)}, StripOptions{})
+	ReleaseStrip(strip)
+
+	canvas := newStripCanvas(image.Rect(0, 0, 32, 48))
+	if canvas.Stride != 4*32 || len(canvas.Pix) != 4*32*48 {
+		t.Fatalf("canvas stride %d and %d bytes, want %d and %d", canvas.Stride, len(canvas.Pix), 4*32, 4*32*48)
+	}
+	for i, v := range canvas.Pix {
+		if v != 0 {
+			t.Fatalf("canvas byte %d = %d, want 0", i, v)
+		}
+	}
+}
+
+func TestReleaseStripClosesTempFile(t *testing.T) {
+	pages := []image.Image{testPage(64, 48), testPage(64, 48)}
+	strip, ok := CompositeStrip(pages, StripOptions{DiskThreshold: 1}).(*fileImage)
+	if !ok {
+		t.Fatal("strip was not composed on disk")
+	}
+	ReleaseStrip(strip)
+
+	if err := strip.readRow(0, make([]byte, 4*64)); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("reading released strip: %v, want os.ErrClosed", err)
+	}
+}
+
+func TestDiskStripScalesPages(t *testing.T) {
+	page := twoColorPage(400, 300, red, blue)
+	opts := StripOptions{GapHeight: 10, DiskThreshold: 1, MaxWidth: 100}
+	strip := CompositeStrip([]image.Image{page, page, page}, opts)
+	defer ReleaseStrip(strip)
+	if _, ok := strip.(*fileImage); !ok {
+		t.Fatalf("strip is a %T, want it composed on disk", strip)
+	}
+
+	// Pages are scaled to 100×75 and the gaps to 2 rows before composing.
+	if got, want := strip.Bounds().Size(), image.Pt(100, 3*75+2*2); got != want {
+		t.Fatalf("strip size = %v, want %v", got, want)
+	}
+	assertColorAt(t, strip, 50, 10, red)
+	assertColorAt(t, strip, 50, 65, blue)
+	assertColorAt(t, strip, 50, 76, color.White)
+	assertColorAt(t, strip, 50, 87, red)
+
+	rects := pageRects([]image.Image{page, page, page}, opts)
+	if got, want := rects[1], image.Rect(0, 77, 100, 152); got != want {
+		t.Errorf("second page rect = %v, want %v", got, want)
+	}
+}
+
+// BenchmarkCompositeStrip composes ten decoded pages with and without
+// handing each strip back to the pool; the pooled run allocates a fraction
+// of the bytes per strip.
+func BenchmarkCompositeStrip(b *testing.B) {
+	pages := make([]image.Image, 10)
+	for i := range pages {
+		pages[i] = testPage(benchmarkPageWidth, benchmarkPageHeight)
+	}
+
+	b.Run("unpooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			CompositeStrip(pages, StripOptions{})
+		}
+	})
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			ReleaseStrip(CompositeStrip(pages, StripOptions{}))
+		}
+	})
+}
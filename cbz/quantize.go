@@ -0,0 +1,14 @@
+package cbz
+
+import "image"
+
+// quantizeImage reduces img to a palette of at most 256 colors. It is nil
+// unless the package is built with the quantize tag, in which case PNGs
+// requested with EncodeOptions.Quantize are encoded as they are.
+var quantizeImage func(img image.Image) (*image.Paletted, error)
+
+// QuantizeSupported reports whether EncodeOptions.Quantize has any effect,
+// that is whether the package was built with the quantize tag.
+func QuantizeSupported() bool {
+	return quantizeImage != nil
+}
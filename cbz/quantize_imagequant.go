@@ -0,0 +1,55 @@
+//go:build quantize
+
+package cbz
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+
+	"github.com/ultimate-guitar/go-imagequant"
+)
+
+// Palette quantization uses libimagequant, the library behind pngquant,
+// which is compiled from source with cgo.
+func init() {
+	quantizeImage = imagequantQuantize
+}
+
+// imagequantQuantize reduces img to 256 colors with libimagequant,
+// dithering to hide the banding of gradients.
+func imagequantQuantize(img image.Image) (*image.Paletted, error) {
+	bounds := img.Bounds()
+	rgba := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+
+	attr, err := imagequant.NewAttributes()
+	if err != nil {
+		return nil, fmt.Errorf("error quantizing image: %v", err)
+	}
+	defer attr.Release()
+
+	liqImage, err := imagequant.NewImage(attr, string(rgba.Pix), bounds.Dx(), bounds.Dy(), 0)
+	if err != nil {
+		return nil, fmt.Errorf("error quantizing image: %v", err)
+	}
+	defer liqImage.Release()
+
+	result, err := liqImage.Quantize(attr)
+	if err != nil {
+		return nil, fmt.Errorf("error quantizing image: %v", err)
+	}
+	defer result.Release()
+
+	indices, err := result.WriteRemappedImage()
+	if err != nil {
+		return nil, fmt.Errorf("error quantizing image: %v", err)
+	}
+
+	return &image.Paletted{
+		Pix:     indices,
+		Stride:  bounds.Dx(),
+		Rect:    rgba.Bounds(),
+		Palette: result.GetPalette(),
+	}, nil
+}
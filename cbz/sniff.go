@@ -0,0 +1,85 @@
+package cbz
+
+import (
+	"bufio"
+	"bytes"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+)
+
+// sniffLen is the number of leading bytes sniffFormat needs.
+const sniffLen = 12
+
+// sniffFormat identifies an image format from the magic bytes at the start of
+// header, returning "" when none match.
+func sniffFormat(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte("\xff\xd8\xff")):
+		return "jpeg"
+	case bytes.HasPrefix(header, []byte("\x89PNG")):
+		return "png"
+	case len(header) >= 12 && bytes.HasPrefix(header, []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WEBP")):
+		return "webp"
+	case bytes.HasPrefix(header, []byte("GIF8")):
+		return "gif"
+	case bytes.HasPrefix(header, []byte("BM")):
+		return "bmp"
+	case bytes.HasPrefix(header, []byte("II*\x00")), bytes.HasPrefix(header, []byte("MM\x00*")):
+		return "tiff"
+	}
+	return ""
+}
+
+// sniffedDecoders map sniffFormat results to the decoder tried first.
+var sniffedDecoders = map[string]func(io.Reader) (image.Image, error){
+	"jpeg": jpeg.Decode,
+	"png":  png.Decode,
+	"webp": webp.Decode,
+	"gif":  decodeGIF,
+	"bmp":  bmp.Decode,
+	"tiff": tiff.Decode,
+}
+
+// sniffedConfigDecoders are the header-only counterparts of sniffedDecoders.
+var sniffedConfigDecoders = map[string]func(io.Reader) (image.Config, error){
+	"jpeg": jpeg.DecodeConfig,
+	"png":  png.DecodeConfig,
+	"webp": webp.DecodeConfig,
+	"gif":  gif.DecodeConfig,
+	"bmp":  bmp.DecodeConfig,
+	"tiff": tiff.DecodeConfig,
+}
+
+// decodeGIF decodes the first frame of a GIF as *image.RGBA.
+func decodeGIF(r io.Reader) (image.Image, error) {
+	img, err := gif.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	return toRGBA(img), nil
+}
+
+// isImageEntry reports whether an archive entry should be treated as a page,
+// either because its magic bytes identify an image or, failing that, because
+// of its extension.
+func isImageEntry(name string, header []byte) bool {
+	return sniffFormat(header) != "" || isImageFile(name)
+}
+
+// peekHeader returns up to sniffLen leading bytes of r without consuming
+// them from the returned reader.
+func peekHeader(r io.Reader) ([]byte, io.Reader, error) {
+	br := bufio.NewReaderSize(r, sniffLen)
+	header, err := br.Peek(sniffLen)
+	if err == io.EOF || err == bufio.ErrBufferFull {
+		err = nil
+	}
+	return header, br, err
+}
@@ -0,0 +1,108 @@
+package cbz
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidSortField is returned when StripOptions.SortField is not one of
+// "name", "size" or "mtime".
+var ErrInvalidSortField = errors.New("invalid sort field")
+
+// sortPages sorts the pages of the archive at archivePath into reading
+// order, the natural order of their names. EPUB pages are already walked in
+// spine order and are left as they are.
+func sortPages[T any](archivePath string, pages []T, name func(T) string) {
+	if strings.EqualFold(filepath.Ext(archivePath), ".epub") {
+		return
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		return naturalLess(name(pages[i]), name(pages[j]))
+	})
+}
+
+// validSortField reports whether field is a StripOptions.SortField value.
+func validSortField(field string) bool {
+	switch field {
+	case "", "name", "size", "mtime":
+		return true
+	}
+	return false
+}
+
+// sortStripPages sorts pages by field, as StripOptions.SortField describes,
+// in descending order if descending is set. Sorting by name is sortPages;
+// size and mtime ties fall back to the natural order of the names.
+func sortStripPages(archivePath string, pages []namedReadCloser, field string, descending bool) {
+	if descending {
+		defer slices.Reverse(pages)
+	}
+
+	var less func(a, b namedReadCloser) bool
+	switch field {
+	case "size":
+		less = func(a, b namedReadCloser) bool { return a.Size < b.Size }
+	case "mtime":
+		less = func(a, b namedReadCloser) bool { return a.ModTime.Before(b.ModTime) }
+	default:
+		sortPages(archivePath, pages, entryName)
+		return
+	}
+
+	sort.SliceStable(pages, func(i, j int) bool {
+		a, b := pages[i], pages[j]
+		if less(a, b) || less(b, a) {
+			return less(a, b)
+		}
+		return naturalLess(a.Name, b.Name)
+	})
+}
+
+// naturalLess compares a and b by splitting them into alternating runs of
+// digits and non-digits, comparing digit runs numerically so that
+// "page9.jpg" sorts before "page10.jpg".
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		aChunk, aIsNum := nextChunk(a)
+		bChunk, bIsNum := nextChunk(b)
+		a, b = a[len(aChunk):], b[len(bChunk):]
+
+		if aIsNum && bIsNum {
+			aTrim := strings.TrimLeft(aChunk, "0")
+			bTrim := strings.TrimLeft(bChunk, "0")
+			if len(aTrim) != len(bTrim) {
+				return len(aTrim) < len(bTrim)
+			}
+			if aTrim != bTrim {
+				return aTrim < bTrim
+			}
+			if len(aChunk) != len(bChunk) {
+				return len(aChunk) < len(bChunk)
+			}
+			continue
+		}
+
+		if aChunk != bChunk {
+			return aChunk < bChunk
+		}
+	}
+	return len(a) < len(b)
+}
+
+// nextChunk returns the leading run of digits or non-digits in s and
+// whether that run is numeric.
+func nextChunk(s string) (string, bool) {
+	isNum := isDigit(s[0])
+	i := 1
+	for i < len(s) && isDigit(s[i]) == isNum {
+		i++
+	}
+	return s[:i], isNum
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
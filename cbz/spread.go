@@ -0,0 +1,40 @@
+package cbz
+
+import (
+	"image"
+	"log/slog"
+)
+
+// spreadAspectRatio is the width-to-height ratio above which a page is taken
+// to be a double-page spread.
+const spreadAspectRatio = 1.5
+
+// splitSpreads replaces every double-page spread among decoded with its two
+// halves, in reading order: left then right, or right then left when
+// rightToLeft is set. pages is expanded alongside so the two slices stay
+// parallel. Nil images, which failed to decode, are left alone.
+func splitSpreads(pages []namedReadCloser, decoded []image.Image, rightToLeft bool) ([]namedReadCloser, []image.Image) {
+	var outPages []namedReadCloser
+	var outImages []image.Image
+	for i, img := range decoded {
+		if img == nil || float64(img.Bounds().Dx()) <= float64(img.Bounds().Dy())*spreadAspectRatio {
+			outPages = append(outPages, pages[i])
+			outImages = append(outImages, img)
+			continue
+		}
+
+		bounds := img.Bounds()
+		mid := bounds.Min.X + bounds.Dx()/2
+		left := cropImage(img, image.Rect(bounds.Min.X, bounds.Min.Y, mid, bounds.Max.Y))
+		right := cropImage(img, image.Rect(mid, bounds.Min.Y, bounds.Max.X, bounds.Max.Y))
+		slog.Info("Splitting double-page spread", "file", pages[i].Name, "width", bounds.Dx(), "height", bounds.Dy())
+
+		outPages = append(outPages, pages[i], pages[i])
+		if rightToLeft {
+			outImages = append(outImages, right, left)
+		} else {
+			outImages = append(outImages, left, right)
+		}
+	}
+	return outPages, outImages
+}
@@ -0,0 +1,879 @@
+// Package cbz decodes comic book archives (CBZ, CBR and CBT) and composes
+// their pages into a single vertical webtoon strip.
+package cbz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sync"
+
+	"golang.org/x/image/draw"
+)
+
+// StripOptions configures how OpenStrip and CompositeStrip build a strip. The
+// zero value reproduces the default behavior.
+type StripOptions struct {
+	// ScaleToWidth resizes pages whose width differs from the first page
+	// instead of skipping them. In horizontal layout pages are matched on
+	// height instead.
+	ScaleToWidth bool
+
+	// Horizontal lays pages out side by side from left to right instead of
+	// stacking them, which suits double-page spreads.
+	Horizontal bool
+
+	// GridColumns, when positive, lays pages out as a grid of thumbnails
+	// this many columns wide for chapter overviews. Each cell is the first
+	// page's width divided by GridColumns, and a partial last row is padded
+	// with GapColor. It takes precedence over Horizontal.
+	GridColumns int
+
+	// GapHeight is the height in pixels of the separator inserted between
+	// consecutive pages, or its width in horizontal layout.
+	GapHeight int
+
+	// GapColor fills the separator rows. Defaults to white.
+	GapColor color.Color
+
+	// BackgroundColor fills the strip behind the pages, showing through
+	// transparent pages and filling the space beside shorter pages in
+	// horizontal layout. Defaults to white, so JPEG output does not turn
+	// transparent areas black.
+	BackgroundColor color.Color
+
+	// RightToLeft composes pages in reverse order for manga read right to
+	// left.
+	RightToLeft bool
+
+	// SelectRange limits the strip to pages StartPage through EndPage
+	// inclusive, 0-indexed in reading order. A negative EndPage selects
+	// through the last page.
+	SelectRange bool
+	StartPage   int
+	EndPage     int
+
+	// SortField orders the pages before any other option applies: "name"
+	// (the default) in natural order, "size" by compressed size, or "mtime"
+	// by the modification time the archive records for each entry. The
+	// latter two suit archives whose entries are named misleadingly. EPUBs
+	// keep their spine order unless sorted by size or mtime. Other values
+	// are rejected with ErrInvalidSortField.
+	SortField string
+
+	// SortDescending reverses the order SortField gives, for archives whose
+	// pages are named in reverse, such as page099.jpg down to page001.jpg.
+	// EPUBs sorted by name are read back to front.
+	SortDescending bool
+
+	// SkipPages leaves out the pages at these 0-indexed positions in
+	// reading order, such as chapter covers or adverts. They are numbered
+	// like StartPage and EndPage, so skipping pages does not shift the
+	// range; positions outside the range are ignored. Positions past the
+	// last page are rejected with ErrInvalidPageRange.
+	SkipPages []int
+
+	// WorkerCount is the number of pages decoded concurrently. Defaults to
+	// runtime.NumCPU().
+	WorkerCount int
+
+	// AutoRotate turns JPEG pages upright according to their EXIF
+	// orientation tag, which phone scans often rely on. DecodeImage and
+	// ExtractPage always do.
+	AutoRotate bool
+
+	// CropMargin trims this many pixels from every edge of each page
+	// before pages are matched on width, removing scanner borders. Pages
+	// too small to keep any pixels are left whole. Negative margins are
+	// rejected with ErrInvalidCropMargin.
+	CropMargin int
+
+	// SplitSpreads splits double-page spreads, pages more than 1.5 times
+	// as wide as they are tall, into two halves placed in reading order:
+	// right before left when RightToLeft is set.
+	SplitSpreads bool
+
+	// SkipBlankPages leaves out pages whose sampled average luminance
+	// exceeds BlankThreshold, such as white separator pages. BlankThreshold
+	// ranges from 0 to 255; zero means DefaultBlankThreshold, so a
+	// threshold of exactly 0 cannot be asked for.
+	SkipBlankPages bool
+	BlankThreshold float64
+
+	// Grayscale converts the composed strip to 8-bit grayscale, which
+	// encodes to a much smaller PNG.
+	Grayscale bool
+
+	// Invert inverts the colors of the composed strip for night reading.
+	// Combined with Grayscale it yields white-on-black pages.
+	Invert bool
+
+	// Brightness is added to every color channel, normalized to 0-1, and
+	// ranges from -1 to 1. Contrast then multiplies it and ranges from 0 to
+	// 3; zero means 1, leaving the strip unchanged.
+	Brightness float64
+	Contrast   float64
+
+	// Watermark is drawn in the bottom-right corner of the strip when not
+	// empty. WatermarkOpacity ranges from 0 to 1; zero means 0.5.
+	Watermark        string
+	WatermarkOpacity float64
+
+	// MaxWidth and MaxHeight, when positive, scale the finished strip down
+	// proportionally so it fits within them, for clients with fixed
+	// viewports. The strip is resampled once, after compositing and
+	// filtering, and is never enlarged.
+	MaxWidth  int
+	MaxHeight int
+
+	// DiskThreshold is the uncompressed size in bytes above which a vertical
+	// or horizontal strip is composed band by band into a temporary file
+	// instead of memory. The decoded pages are still held in memory. Zero
+	// means DefaultDiskThreshold; a negative value always composes in
+	// memory.
+	DiskThreshold int64
+
+	// OnPageDecoded, when set, is called after each selected page has been
+	// decoded, or has failed to, with the page's 0-based index among the
+	// selected pages, their total, and the page as decoded, or nil if it
+	// failed. The page has not been cropped, split or matched on width yet;
+	// see PreparePage. Pages are decoded concurrently, so calls arrive in no
+	// particular order, but never overlap.
+	OnPageDecoded func(index, total int, page image.Image)
+
+	// OnDecodeStart, when set, is called once with the number of selected
+	// pages, after SelectRange and SkipPages apply and before any page is
+	// decoded, so pages can be laid out before they arrive. OpenStrips calls
+	// it once per archive.
+	OnDecodeStart func(total int)
+
+	// MaxInputBytes rejects archives larger than this many bytes. Zero means
+	// no limit.
+	MaxInputBytes int64
+
+	// MaxOutputPixels rejects strips whose width times height exceeds this
+	// many pixels. Zero means DefaultMaxOutputPixels; a negative value
+	// disables the check.
+	MaxOutputPixels int
+}
+
+// DefaultMaxOutputPixels is the output size limit applied when
+// StripOptions.MaxOutputPixels is zero.
+const DefaultMaxOutputPixels = 200_000_000
+
+var (
+	// ErrFileTooLarge is returned when an archive exceeds
+	// StripOptions.MaxInputBytes.
+	ErrFileTooLarge = errors.New("archive too large")
+
+	// ErrOutputTooLarge is returned when a strip would exceed
+	// StripOptions.MaxOutputPixels.
+	ErrOutputTooLarge = errors.New("output image too large")
+)
+
+// StripResult reports which pages of an archive made it into a strip.
+type StripResult struct {
+	// PageCount is the number of pages composed into the strip.
+	PageCount int
+
+	// SkippedPages counts selected pages left out because they failed to
+	// decode or had a mismatched width.
+	SkippedPages int
+
+	// SkippedBlankPages counts pages left out because
+	// StripOptions.SkipBlankPages found them blank. They are not included in
+	// SkippedPages.
+	SkippedBlankPages int
+
+	// PageWidths holds the original width of each composed page in reading
+	// order, before any scaling.
+	PageWidths []int
+
+	// PageRects holds where each composed page lies in the strip, in
+	// reading order, for sprite sheets and client-side page navigation.
+	PageRects []image.Rectangle
+
+	// CorruptPages names the archive entries skipped because they could not
+	// be read or decoded.
+	CorruptPages []string
+
+	// FormatCounts counts the selected pages decoded as each image format,
+	// such as "jpeg" or "webp", whether or not they made it into the strip.
+	FormatCounts map[string]int
+
+	// FailedDecodes counts the selected pages skipped because they could
+	// not be decoded. They are included in SkippedPages.
+	FailedDecodes int
+}
+
+// CreateWebtoonStrip decodes every image in the archive at cbzFilePath and
+// stacks them vertically into a single image. If ctx is done before every
+// page is decoded, it returns ctx's error.
+func CreateWebtoonStrip(ctx context.Context, cbzFilePath string, opts StripOptions) (image.Image, StripResult, error) {
+	pages, result, err := OpenStrip(ctx, cbzFilePath, opts)
+	if err != nil {
+		return nil, result, err
+	}
+	return CompositeStrip(pages, opts), result, nil
+}
+
+// OpenStrip decodes the pages of the archive at cbzFilePath in reading order,
+// ready to be passed to CompositeStrip. Pages that fail to decode are skipped,
+// as are pages whose width differs from the first page unless
+// opts.ScaleToWidth is set. Decoding stops with ctx's error once ctx is
+// done.
+func OpenStrip(ctx context.Context, cbzFilePath string, opts StripOptions) ([]image.Image, StripResult, error) {
+	if opts.CropMargin < 0 {
+		return nil, StripResult{}, fmt.Errorf("%w: %d", ErrInvalidCropMargin, opts.CropMargin)
+	}
+
+	if !validSortField(opts.SortField) {
+		return nil, StripResult{}, fmt.Errorf("%w: %q", ErrInvalidSortField, opts.SortField)
+	}
+
+	if opts.MaxInputBytes > 0 {
+		info, err := os.Stat(cbzFilePath)
+		if err != nil {
+			return nil, StripResult{}, err
+		}
+		if info.Size() > opts.MaxInputBytes {
+			return nil, StripResult{}, fmt.Errorf("%w: %d bytes exceeds the %d byte limit", ErrFileTooLarge, info.Size(), opts.MaxInputBytes)
+		}
+	}
+
+	entries, corrupt, err := openArchivePages(cbzFilePath)
+	if err != nil {
+		return nil, StripResult{}, err
+	}
+
+	var pages []namedReadCloser
+	var comicInfo *ComicInfo
+	for _, entry := range entries {
+		header, err := entry.peekHeader()
+		if err != nil {
+			return nil, StripResult{}, fmt.Errorf("error reading file %s: %v", entry.Name, err)
+		}
+		if !isImageEntry(entry.Name, header) {
+			if isComicInfoEntry(entry.Name) {
+				if comicInfo, err = parseComicInfo(entry); err != nil {
+					slog.Error("Error reading ComicInfo.xml", "file", entry.Name, "error", err)
+				}
+			}
+			entry.Close()
+			continue
+		}
+		pages = append(pages, entry)
+	}
+
+	sortStripPages(cbzFilePath, pages, opts.SortField, opts.SortDescending)
+
+	if len(pages) == 0 {
+		return nil, StripResult{}, fmt.Errorf("no images found in the archive")
+	}
+
+	if comicInfo != nil && comicInfo.PageCount > 0 && comicInfo.PageCount != len(pages) {
+		slog.Warn("ComicInfo.xml page count does not match archive", "page_count", comicInfo.PageCount, "images", len(pages))
+	}
+
+	skip := make(map[int]bool, len(opts.SkipPages))
+	for _, i := range opts.SkipPages {
+		if i < 0 || i >= len(pages) {
+			return nil, StripResult{}, fmt.Errorf("%w: page %d skipped but archive has %d pages", ErrInvalidPageRange, i, len(pages))
+		}
+		skip[i] = true
+	}
+
+	first := 0
+	if opts.SelectRange {
+		end := opts.EndPage
+		if end < 0 {
+			end = len(pages) - 1
+		}
+		if opts.StartPage < 0 || opts.StartPage > end || end >= len(pages) {
+			return nil, StripResult{}, fmt.Errorf("%w: pages %d-%d requested but archive has %d pages", ErrInvalidPageRange, opts.StartPage, end, len(pages))
+		}
+		pages = pages[opts.StartPage : end+1]
+		first = opts.StartPage
+	}
+
+	if len(skip) > 0 {
+		kept := pages[:0]
+		for i, page := range pages {
+			if !skip[first+i] {
+				kept = append(kept, page)
+			}
+		}
+		pages = kept
+		if len(pages) == 0 {
+			return nil, StripResult{}, fmt.Errorf("%w: every selected page is skipped", ErrInvalidPageRange)
+		}
+	}
+
+	var images []image.Image
+	result := StripResult{CorruptPages: corrupt, FormatCounts: make(map[string]int)}
+	var commonSize int
+
+	decoded, formats, err := decodePages(ctx, pages, opts)
+	if err != nil {
+		return nil, StripResult{}, err
+	}
+	for i, format := range formats {
+		if decoded[i] == nil {
+			result.FailedDecodes++
+			continue
+		}
+		result.FormatCounts[format]++
+	}
+	if opts.CropMargin > 0 {
+		cropMargins(pages, decoded, opts.CropMargin)
+	}
+	if opts.SplitSpreads {
+		pages, decoded = splitSpreads(pages, decoded, opts.RightToLeft)
+	}
+
+	for i, entry := range pages {
+		img := decoded[i]
+		if img == nil {
+			result.SkippedPages++
+			result.CorruptPages = append(result.CorruptPages, entry.Name)
+			continue // Failed to decode; already logged
+		}
+
+		if opts.SkipBlankPages && isBlankPage(img, opts.BlankThreshold) {
+			slog.Info("Skipping blank page", "file", entry.Name)
+			result.SkippedBlankPages++
+			continue
+		}
+
+		dim, size := "width", img.Bounds().Dx()
+		if opts.Horizontal {
+			dim, size = "height", img.Bounds().Dy()
+		}
+
+		if commonSize == 0 {
+			commonSize = size
+		} else if size != commonSize && opts.ScaleToWidth {
+			slog.Info("Scaling page to common "+dim, "file", entry.Name, dim, size, "common_"+dim, commonSize)
+			if opts.Horizontal {
+				img = scaleToHeight(img, commonSize)
+			} else {
+				img = scaleToWidth(img, commonSize)
+			}
+		} else if size != commonSize {
+			slog.Info("Skipping page with mismatched "+dim, "file", entry.Name, dim, size, "common_"+dim, commonSize)
+			result.SkippedPages++
+			continue
+		}
+
+		result.PageWidths = append(result.PageWidths, decoded[i].Bounds().Dx())
+		images = append(images, img)
+	}
+
+	if len(images) == 0 {
+		if opts.Horizontal {
+			return nil, StripResult{}, fmt.Errorf("no valid images found with matching height in the archive")
+		}
+		return nil, StripResult{}, fmt.Errorf("no valid images found with matching width in the archive")
+	}
+
+	if err := checkOutputSize(images, opts); err != nil {
+		return nil, StripResult{}, err
+	}
+
+	result.PageCount = len(images)
+	result.PageRects = pageRects(images, opts)
+	return images, result, nil
+}
+
+// PreparePage applies the steps OpenStrip takes for each decoded page on
+// its own, CropMargin, SplitSpreads and SkipBlankPages, to page. It returns
+// the page, the halves of a split spread in reading order, or nothing if the
+// page is blank. Matching widths depends on the other pages and is left
+// out.
+func PreparePage(page image.Image, opts StripOptions) []image.Image {
+	entries := []namedReadCloser{{}}
+	decoded := []image.Image{page}
+	if opts.CropMargin > 0 {
+		cropMargins(entries, decoded, opts.CropMargin)
+	}
+	if opts.SplitSpreads {
+		_, decoded = splitSpreads(entries, decoded, opts.RightToLeft)
+	}
+	if opts.SkipBlankPages {
+		decoded = slices.DeleteFunc(decoded, func(img image.Image) bool {
+			return isBlankPage(img, opts.BlankThreshold)
+		})
+	}
+	return decoded
+}
+
+// OpenStrips is OpenStrip for several archives read one after another, such
+// as the chapters of an arc. The archives are decoded concurrently. Pages
+// whose width differs from the first archive's are scaled when
+// opts.ScaleToWidth is set and skipped otherwise, and opts.MaxOutputPixels
+// applies to the combined strip.
+func OpenStrips(ctx context.Context, cbzFilePaths []string, opts StripOptions) ([]image.Image, StripResult, error) {
+	chapters := make([][]image.Image, len(cbzFilePaths))
+	results := make([]StripResult, len(cbzFilePaths))
+	errs := make([]error, len(cbzFilePaths))
+
+	var wg sync.WaitGroup
+	for i, path := range cbzFilePaths {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			chapters[i], results[i], errs[i] = OpenStrip(ctx, path, opts)
+		}()
+	}
+	wg.Wait()
+
+	var images []image.Image
+	result := StripResult{FormatCounts: make(map[string]int)}
+	for i, chapter := range chapters {
+		if errs[i] != nil {
+			return nil, StripResult{}, fmt.Errorf("%s: %w", filepath.Base(cbzFilePaths[i]), errs[i])
+		}
+
+		for format, count := range results[i].FormatCounts {
+			result.FormatCounts[format] += count
+		}
+		result.FailedDecodes += results[i].FailedDecodes
+		result.SkippedPages += results[i].SkippedPages
+		result.SkippedBlankPages += results[i].SkippedBlankPages
+		result.CorruptPages = append(result.CorruptPages, results[i].CorruptPages...)
+		for j, img := range chapter {
+			if len(images) > 0 && pageSize(img, opts) != pageSize(images[0], opts) {
+				if !opts.ScaleToWidth {
+					slog.Info("Skipping page with mismatched size", "file", cbzFilePaths[i], "page", j)
+					result.SkippedPages++
+					continue
+				}
+				if opts.Horizontal {
+					img = scaleToHeight(img, pageSize(images[0], opts))
+				} else {
+					img = scaleToWidth(img, pageSize(images[0], opts))
+				}
+			}
+
+			result.PageWidths = append(result.PageWidths, results[i].PageWidths[j])
+			images = append(images, img)
+		}
+	}
+
+	if err := checkOutputSize(images, opts); err != nil {
+		return nil, StripResult{}, err
+	}
+
+	result.PageCount = len(images)
+	result.PageRects = pageRects(images, opts)
+	return images, result, nil
+}
+
+// pageSize returns the dimension pages are matched on: the width, or the
+// height in horizontal layout.
+func pageSize(img image.Image, opts StripOptions) int {
+	if opts.Horizontal {
+		return img.Bounds().Dy()
+	}
+	return img.Bounds().Dx()
+}
+
+// maxOutputPixels returns the output size limit opts.MaxOutputPixels sets,
+// or a negative value if there is none.
+func maxOutputPixels(opts StripOptions) int {
+	if opts.MaxOutputPixels == 0 {
+		return DefaultMaxOutputPixels
+	}
+	return opts.MaxOutputPixels
+}
+
+// checkOutputSize returns ErrOutputTooLarge if composing pages would exceed
+// opts.MaxOutputPixels.
+func checkOutputSize(pages []image.Image, opts StripOptions) error {
+	if maxPixels := maxOutputPixels(opts); maxPixels > 0 {
+		width, height := stripSize(pages, opts)
+		if pixels := int64(width) * int64(height); pixels > int64(maxPixels) {
+			return fmt.Errorf("%w: %dx%d exceeds the %d pixel limit", ErrOutputTooLarge, width, height, maxPixels)
+		}
+	}
+	return nil
+}
+
+// pageRects returns where CompositeStrip places each of pages, indexed like
+// pages even when opts.RightToLeft reverses them.
+func pageRects(pages []image.Image, opts StripOptions) []image.Rectangle {
+	if scale := pageScale(pages, opts); scale < 1 {
+		pages, opts = scaledLayout(pages, scale, opts)
+	}
+
+	var rects []image.Rectangle
+	if opts.RightToLeft {
+		reversed := slices.Clone(pages)
+		slices.Reverse(reversed)
+		rects = layoutRects(reversed, opts)
+		slices.Reverse(rects)
+	} else {
+		rects = layoutRects(pages, opts)
+	}
+
+	// Scale the rectangles along with the strip when it is fitted to
+	// opts.MaxWidth and opts.MaxHeight.
+	width, height := stripSize(pages, opts)
+	fitWidth, fitHeight := fitSize(width, height, opts)
+	if fitWidth != width || fitHeight != height {
+		for i, rect := range rects {
+			rects[i] = image.Rect(
+				rect.Min.X*fitWidth/width, rect.Min.Y*fitHeight/height,
+				rect.Max.X*fitWidth/width, rect.Max.Y*fitHeight/height,
+			)
+		}
+	}
+	return rects
+}
+
+// layoutRects returns the rectangle each of pages occupies when laid out in
+// the given order.
+func layoutRects(pages []image.Image, opts StripOptions) []image.Rectangle {
+	rects := make([]image.Rectangle, len(pages))
+	if opts.GridColumns > 0 {
+		cellWidth, cellHeight := gridCellSize(pages, opts)
+		for i, img := range pages {
+			x := (i % opts.GridColumns) * (cellWidth + opts.GapHeight)
+			y := (i / opts.GridColumns) * (cellHeight + opts.GapHeight)
+			height := max(1, img.Bounds().Dy()*cellWidth/img.Bounds().Dx())
+			rects[i] = image.Rect(x, y, x+cellWidth, y+height)
+		}
+		return rects
+	}
+
+	current := 0
+	for i, img := range pages {
+		if opts.Horizontal {
+			rects[i] = image.Rect(current, 0, current+img.Bounds().Dx(), img.Bounds().Dy())
+			current += img.Bounds().Dx() + opts.GapHeight
+		} else {
+			rects[i] = image.Rect(0, current, img.Bounds().Dx(), current+img.Bounds().Dy())
+			current += img.Bounds().Dy() + opts.GapHeight
+		}
+	}
+	return rects
+}
+
+// stripSize returns the dimensions CompositeStrip will produce for pages.
+func stripSize(pages []image.Image, opts StripOptions) (int, int) {
+	if opts.GridColumns > 0 {
+		return gridSize(pages, opts)
+	}
+	if opts.Horizontal {
+		width, height := (len(pages)-1)*opts.GapHeight, 0
+		for _, img := range pages {
+			width += img.Bounds().Dx()
+			height = max(height, img.Bounds().Dy())
+		}
+		return width, height
+	}
+
+	height := (len(pages) - 1) * opts.GapHeight
+	for _, img := range pages {
+		height += img.Bounds().Dy()
+	}
+	return pages[0].Bounds().Dx(), height
+}
+
+// CompositeStrip stacks pages vertically into a single image as wide as the
+// first page, separated by opts.GapHeight rows of opts.GapColor, or places
+// them side by side or in a grid when opts.Horizontal or opts.GridColumns is
+// set. Pages must be non-empty; OpenStrip guarantees this.
+func CompositeStrip(pages []image.Image, opts StripOptions) image.Image {
+	images := pages
+	if opts.RightToLeft {
+		images = slices.Clone(pages)
+		slices.Reverse(images)
+	}
+
+	totalWidth, totalHeight := stripSize(images, opts)
+
+	gapColor := opts.GapColor
+	if gapColor == nil {
+		gapColor = color.White
+	}
+
+	// Scaling a strip that lives on disk would read it back pixel by pixel
+	// and buffer a float row for every source row, so large strips that
+	// will be scaled anyway are composed from scaled pages instead.
+	if scale := pageScale(images, opts); scale < 1 {
+		images, opts = scalePages(images, scale, opts)
+		totalWidth, totalHeight = stripSize(images, opts)
+	}
+	if spillsToDisk(totalWidth, totalHeight, opts) {
+		img, err := compositeToDisk(images, totalWidth, totalHeight, opts, gapColor)
+		if err == nil {
+			return img
+		}
+		slog.Warn("Composing strip in memory after temp file failed", "error", err)
+	}
+
+	finalImage := newStripCanvas(image.Rect(0, 0, totalWidth, totalHeight))
+	if opts.GridColumns > 0 {
+		drawGrid(finalImage, images, opts, gapColor)
+	} else {
+		drawStrip(finalImage, images, opts, gapColor)
+	}
+
+	strip := fitStrip(applyFilters(finalImage, finalImage.Bounds(), opts), opts)
+	// Grayscale and resized strips are copies; the canvas can be reused.
+	if strip != image.Image(finalImage) {
+		ReleaseStrip(finalImage)
+	}
+	return strip
+}
+
+// fitSize returns the dimensions of a width×height strip scaled down to fit
+// within opts.MaxWidth and opts.MaxHeight, preserving its aspect ratio.
+func fitSize(width, height int, opts StripOptions) (int, int) {
+	scale := fitScale(width, height, opts)
+	if scale == 1 {
+		return width, height
+	}
+	return max(1, int(math.Round(float64(width)*scale))), max(1, int(math.Round(float64(height)*scale)))
+}
+
+// fitScale returns the factor fitSize scales a width×height strip by, or 1
+// when it already fits.
+func fitScale(width, height int, opts StripOptions) float64 {
+	scale := 1.0
+	if opts.MaxWidth > 0 && width > opts.MaxWidth {
+		scale = float64(opts.MaxWidth) / float64(width)
+	}
+	if opts.MaxHeight > 0 && height > opts.MaxHeight {
+		scale = min(scale, float64(opts.MaxHeight)/float64(height))
+	}
+	return scale
+}
+
+// spillsToDisk reports whether CompositeStrip composes a width×height strip
+// in a temporary file rather than in memory.
+func spillsToDisk(width, height int, opts StripOptions) bool {
+	threshold := opts.DiskThreshold
+	if threshold == 0 {
+		threshold = DefaultDiskThreshold
+	}
+	return opts.GridColumns == 0 && threshold > 0 && int64(width)*int64(height)*4 > threshold
+}
+
+// pageScale returns the factor CompositeStrip scales pages by before
+// composing them, which is below 1 only for strips that are composed on disk
+// and have to be fitted to opts.MaxWidth and opts.MaxHeight.
+func pageScale(pages []image.Image, opts StripOptions) float64 {
+	width, height := stripSize(pages, opts)
+	if !spillsToDisk(width, height, opts) {
+		return 1
+	}
+	return fitScale(width, height, opts)
+}
+
+// scaledLayout returns the bounds of pages and opts with the gap between
+// them scaled by scale. Sizes are rounded down so the strip they compose
+// still fits within opts.MaxWidth and opts.MaxHeight.
+func scaledLayout(pages []image.Image, scale float64, opts StripOptions) ([]image.Image, StripOptions) {
+	sizes := make([]image.Image, len(pages))
+	for i, page := range pages {
+		bounds := page.Bounds()
+		sizes[i] = image.Rect(0, 0, max(1, int(float64(bounds.Dx())*scale)), max(1, int(float64(bounds.Dy())*scale)))
+	}
+	opts.GapHeight = int(float64(opts.GapHeight) * scale)
+	return sizes, opts
+}
+
+// scalePages scales pages to their scaledLayout with draw.CatmullRom.
+func scalePages(pages []image.Image, scale float64, opts StripOptions) ([]image.Image, StripOptions) {
+	sizes, opts := scaledLayout(pages, scale, opts)
+	scaled := make([]image.Image, len(pages))
+	for i, page := range pages {
+		dst := image.NewRGBA(sizes[i].Bounds())
+		draw.CatmullRom.Scale(dst, dst.Bounds(), page, page.Bounds(), draw.Src, nil)
+		scaled[i] = dst
+	}
+	return scaled, opts
+}
+
+// fitStrip scales img down to fitSize with draw.CatmullRom, keeping
+// grayscale strips grayscale.
+func fitStrip(img image.Image, opts StripOptions) image.Image {
+	bounds := img.Bounds()
+	width, height := fitSize(bounds.Dx(), bounds.Dy(), opts)
+	if width == bounds.Dx() && height == bounds.Dy() {
+		return img
+	}
+
+	var scaled draw.Image
+	if _, ok := img.(*image.Gray); ok {
+		scaled = image.NewGray(image.Rect(0, 0, width, height))
+	} else {
+		scaled = image.NewRGBA(image.Rect(0, 0, width, height))
+	}
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), img, bounds, draw.Src, nil)
+	return scaled
+}
+
+// applyFilters applies the watermark and color filters in opts to img, which
+// covers the part of a strip with bounds area, and returns the result.
+func applyFilters(img *image.RGBA, area image.Rectangle, opts StripOptions) image.Image {
+	if opts.Watermark != "" {
+		applyWatermark(img, area, opts)
+	}
+	if opts.Brightness != 0 || (opts.Contrast != 0 && opts.Contrast != 1) {
+		contrast := opts.Contrast
+		if contrast == 0 {
+			contrast = 1
+		}
+		img = adjustImage(img, opts.Brightness, contrast)
+	}
+	if opts.Invert {
+		img = invertImage(img)
+	}
+	if opts.Grayscale {
+		return toGrayscale(img)
+	}
+	return img
+}
+
+// drawStrip fills dst with opts.BackgroundColor and places pages over it one
+// after another, top to bottom or left to right, separated by
+// opts.GapHeight pixels of gapColor. Pages are positioned from the origin, so
+// dst may cover just a band of the strip as long as it spans the strip's full
+// width.
+func drawStrip(dst *image.RGBA, pages []image.Image, opts StripOptions, gapColor color.Color) {
+	bounds := dst.Bounds()
+
+	background := opts.BackgroundColor
+	if background == nil {
+		background = color.White
+	}
+	draw.Draw(dst, bounds, image.NewUniform(background), image.Point{}, draw.Src)
+
+	rects := layoutRects(pages, opts)
+	for i, img := range pages {
+		if i > 0 && opts.GapHeight > 0 {
+			gap := image.Rect(0, rects[i-1].Max.Y, bounds.Max.X, rects[i].Min.Y)
+			if opts.Horizontal {
+				gap = image.Rect(rects[i-1].Max.X, bounds.Min.Y, rects[i].Min.X, bounds.Max.Y)
+			}
+			draw.Draw(dst, gap, image.NewUniform(gapColor), image.Point{}, draw.Src)
+		}
+
+		draw.Draw(dst, rects[i], img, image.Point{}, draw.Over)
+	}
+}
+
+// decodePages decodes pages concurrently using up to opts.WorkerCount
+// goroutines and returns the images in the same order, rotated upright if
+// opts.AutoRotate is set, along with the format each was decoded as. Pages
+// that fail to decode are logged and left nil. A page whose header alone
+// exceeds opts.MaxOutputPixels stops decoding with ErrOutputTooLarge, before
+// its pixels are decoded. Once ctx is done no further pages are started and
+// ctx's error is returned.
+func decodePages(ctx context.Context, pages []namedReadCloser, opts StripOptions) ([]image.Image, []string, error) {
+	workers := opts.WorkerCount
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	maxPixels := maxOutputPixels(opts)
+	if opts.OnDecodeStart != nil {
+		opts.OnDecodeStart(len(pages))
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	images := make([]image.Image, len(pages))
+	formats := make([]string, len(pages))
+	jobs := make(chan int)
+
+	// mu serializes calls to opts.OnPageDecoded.
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for range min(workers, len(pages)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				entry := pages[i]
+				if ctx.Err() != nil {
+					entry.Close()
+					continue
+				}
+
+				img, format, err := decodePage(entry, opts.AutoRotate, maxPixels)
+				entry.Close()
+				if errors.Is(err, ErrOutputTooLarge) {
+					cancel(fmt.Errorf("%s: %w", entry.Name, err))
+					continue
+				}
+				if opts.OnPageDecoded != nil {
+					mu.Lock()
+					opts.OnPageDecoded(i, len(pages), img)
+					mu.Unlock()
+				}
+				if err != nil {
+					slog.Error("Error decoding page", "file", entry.Name, "error", err)
+					continue // Skip this file and try the next one
+				}
+
+				bounds := img.Bounds()
+				slog.Info("Decoded page", "file", entry.Name, "format", format, "width", bounds.Dx(), "height", bounds.Dy())
+				images[i], formats[i] = img, format
+			}
+		}()
+	}
+
+feed:
+	for i := range pages {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return nil, nil, context.Cause(ctx)
+	}
+	return images, formats, nil
+}
+
+// scaleToWidth resizes img to the given width, preserving its aspect ratio.
+func scaleToWidth(img image.Image, width int) image.Image {
+	bounds := img.Bounds()
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), img, bounds, draw.Src, nil)
+	return scaled
+}
+
+// scaleToHeight resizes img to the given height, preserving its aspect ratio.
+func scaleToHeight(img image.Image, height int) image.Image {
+	bounds := img.Bounds()
+	width := bounds.Dx() * height / bounds.Dy()
+	if width < 1 {
+		width = 1
+	}
+
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), img, bounds, draw.Src, nil)
+	return scaled
+}
@@ -0,0 +1,258 @@
+package cbz
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fixtureDir holds the archives TestMain generates for the package's tests.
+var fixtureDir string
+
+// archiveEntry is a file stored in a generated fixture archive.
+type archiveEntry struct {
+	name string
+	data []byte
+}
+
+// fixtures lists the archives TestMain writes to fixtureDir, by file name.
+var fixtures = map[string][]archiveEntry{
+	"single.cbz": {
+		{"001.jpg", jpegPage(120, 200)},
+	},
+	"mixed.cbz": {
+		{"001.jpg", jpegPage(120, 200)},
+		{"002.png", pngPage(120, 150)},
+		{"003.jpg", jpegPage(120, 90)},
+	},
+	"mismatched.cbz": {
+		{"001.png", pngPage(120, 200)},
+		{"002.png", pngPage(80, 300)},
+		{"003.png", pngPage(120, 100)},
+	},
+	"empty.cbz":    {},
+	"noimages.cbz": {{"ComicInfo.xml", []byte("<ComicInfo></ComicInfo>")}, {"readme.txt", []byte("not a page")}},
+	"pixel.cbz":    {{"001.png", pngPage(1, 1)}},
+}
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	dir, err := os.MkdirTemp("", "cbz-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fixtureDir = dir
+
+	for name, entries := range fixtures {
+		if err := os.WriteFile(filepath.Join(dir, name), zipBytes(entries), 0o644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.RemoveAll(dir)
+			os.Exit(1)
+		}
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// testPage returns a w by h image filled with a gradient, so scaled or
+// misplaced pages show up as pixel differences.
+func testPage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x * 255 / max(w, 1)), uint8(y * 255 / max(h, 1)), 128, 255})
+		}
+	}
+	return img
+}
+
+// jpegPage returns testPage(w, h) encoded as a JPEG.
+func jpegPage(w, h int) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testPage(w, h), &jpeg.Options{Quality: 90}); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// pngPage returns testPage(w, h) encoded as a PNG.
+func pngPage(w, h int) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testPage(w, h)); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// zipBytes returns a zip archive holding entries in order.
+func zipBytes(entries []archiveEntry) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		w, err := zw.Create(entry.name)
+		if err != nil {
+			panic(err)
+		}
+		if _, err := w.Write(entry.data); err != nil {
+			panic(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// writeTestArchive writes entries as a zip archive named name in a
+// temporary directory of t and returns its path.
+func writeTestArchive(t testing.TB, name string, entries ...archiveEntry) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, zipBytes(entries), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCreateWebtoonStrip(t *testing.T) {
+	tests := []struct {
+		name          string
+		archive       string
+		width, height int
+		pages         int
+		skipped       int
+		wantErr       bool
+	}{
+		{name: "single JPEG page", archive: "single.cbz", width: 120, height: 200, pages: 1},
+		{name: "mixed JPEG and PNG", archive: "mixed.cbz", width: 120, height: 200 + 150 + 90, pages: 3},
+		{name: "width mismatch skips page", archive: "mismatched.cbz", width: 120, height: 200 + 100, pages: 2, skipped: 1},
+		{name: "empty archive", archive: "empty.cbz", wantErr: true},
+		{name: "only non-image files", archive: "noimages.cbz", wantErr: true},
+		{name: "single pixel", archive: "pixel.cbz", width: 1, height: 1, pages: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, result, err := CreateWebtoonStrip(context.Background(), filepath.Join(fixtureDir, tt.archive), StripOptions{})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("CreateWebtoonStrip succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CreateWebtoonStrip: %v", err)
+			}
+
+			if got := img.Bounds().Size(); got != image.Pt(tt.width, tt.height) {
+				t.Errorf("strip size = %v, want %v", got, image.Pt(tt.width, tt.height))
+			}
+			if result.PageCount != tt.pages {
+				t.Errorf("PageCount = %d, want %d", result.PageCount, tt.pages)
+			}
+			if result.SkippedPages != tt.skipped {
+				t.Errorf("SkippedPages = %d, want %d", result.SkippedPages, tt.skipped)
+			}
+		})
+	}
+}
+
+func TestPreparePage(t *testing.T) {
+	spread := testPage(300, 100)
+	if got := PreparePage(spread, StripOptions{}); len(got) != 1 {
+		t.Errorf("PreparePage without options returned %d pages, want 1", len(got))
+	}
+
+	halves := PreparePage(spread, StripOptions{SplitSpreads: true, CropMargin: 10})
+	if len(halves) != 2 {
+		t.Fatalf("PreparePage split a spread into %d pages, want 2", len(halves))
+	}
+	if got := halves[0].Bounds().Size(); got != image.Pt(140, 80) {
+		t.Errorf("cropped half size = %v, want (140,80)", got)
+	}
+
+	blank := image.NewGray(image.Rect(0, 0, 50, 50))
+	for i := range blank.Pix {
+		blank.Pix[i] = 255
+	}
+	if got := PreparePage(blank, StripOptions{SkipBlankPages: true}); len(got) != 0 {
+		t.Errorf("PreparePage kept %d pages of a blank page, want 0", len(got))
+	}
+}
+
+// pngWithSize returns a 1x1 PNG whose header claims to be w by h, as a
+// decompression bomb would.
+func pngWithSize(w, h uint32) []byte {
+	data := pngPage(1, 1)
+	// The IHDR chunk follows the 8-byte signature: length, type, then the
+	// width and height, and its CRC after the 13 bytes of data.
+	ihdr := data[8+4 : 8+4+4+13]
+	binary.BigEndian.PutUint32(ihdr[4:], w)
+	binary.BigEndian.PutUint32(ihdr[8:], h)
+	binary.BigEndian.PutUint32(data[8+4+4+13:], crc32.ChecksumIEEE(ihdr))
+	return data
+}
+
+func TestCreateWebtoonStripChecksPageSizeBeforeDecoding(t *testing.T) {
+	path := writeTestArchive(t, "bomb.cbz",
+		archiveEntry{"001.png", pngPage(100, 100)},
+		archiveEntry{"002.png", pngWithSize(100_000, 100_000)},
+	)
+
+	_, _, err := CreateWebtoonStrip(context.Background(), path, StripOptions{})
+	if !errors.Is(err, ErrOutputTooLarge) {
+		t.Fatalf("CreateWebtoonStrip error = %v, want ErrOutputTooLarge", err)
+	}
+}
+
+// slowReader sleeps before every read, like an archive on a slow disk.
+type slowReader struct {
+	r     io.Reader
+	delay time.Duration
+}
+
+func (s slowReader) Read(p []byte) (int, error) {
+	time.Sleep(s.delay)
+	return s.r.Read(p)
+}
+
+func TestDecodePagesStopsAtDeadline(t *testing.T) {
+	page := pngPage(120, 200)
+	slow := &countingReader{r: slowReader{bytes.NewReader(page), 20 * time.Millisecond}}
+	rest := make([]*countingReader, 5)
+	pages := []namedReadCloser{{Name: "001.png", ReadCloser: io.NopCloser(slow)}}
+	for i := range rest {
+		rest[i] = &countingReader{r: bytes.NewReader(page)}
+		pages = append(pages, namedReadCloser{Name: fmt.Sprintf("%03d.png", i+2), ReadCloser: io.NopCloser(rest[i])})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	_, _, err := decodePages(ctx, pages, StripOptions{WorkerCount: 1})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("decodePages error = %v, want context.DeadlineExceeded", err)
+	}
+	for i, r := range rest {
+		if r.n != 0 {
+			t.Errorf("page %d was read after the deadline", i+2)
+		}
+	}
+}
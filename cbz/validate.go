@@ -0,0 +1,98 @@
+package cbz
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ValidationIssue is a problem ValidateArchive found with an entry.
+type ValidationIssue struct {
+	// Page is the name of the entry concerned; for missing pages, the page
+	// that follows them.
+	Page    string `json:"page"`
+	Message string `json:"message"`
+}
+
+// pageNumberPattern matches the page number at the end of a file name,
+// such as "012", or the range of a spread stored as one image, such as
+// "012-013".
+var pageNumberPattern = regexp.MustCompile(`(\d+)(?:-(\d+))?$`)
+
+// numberedPage is a page whose name ends in a page number or range.
+type numberedPage struct {
+	name        string
+	first, last int
+}
+
+// ValidateArchive checks the archive at cbzPath for signs of corruption or a
+// botched repack: gaps in the numbering of its pages, such as pages 5-10
+// missing between 4 and 11, entries stored more than once under the same
+// name, and empty entries. Pages are numbered by the digits their names end
+// in, and are only compared with pages that share the rest of the name, so
+// "cover.jpg" or the pages of two chapters in one archive do not count as
+// gaps. Entries are only read as far as needed to tell whether they are
+// images. An archive without issues yields an empty slice.
+func ValidateArchive(cbzPath string) ([]ValidationIssue, error) {
+	issues := []ValidationIssue{}
+	seen := make(map[string]bool)
+	sequences := make(map[string][]numberedPage)
+
+	err := walkArchive(cbzPath, func(name string, r io.Reader) (bool, error) {
+		header, _, err := peekHeader(r)
+		if err != nil {
+			return false, fmt.Errorf("error reading file %s: %v", name, err)
+		}
+
+		if seen[name] {
+			issues = append(issues, ValidationIssue{Page: name, Message: "duplicate entry name"})
+		}
+		seen[name] = true
+		if len(header) == 0 {
+			issues = append(issues, ValidationIssue{Page: name, Message: "entry is empty"})
+		}
+
+		if !isImageEntry(name, header) {
+			return true, nil
+		}
+		stem := strings.TrimSuffix(name, path.Ext(name))
+		match := pageNumberPattern.FindStringSubmatchIndex(stem)
+		if match == nil {
+			return true, nil
+		}
+		page := numberedPage{name: name}
+		page.first, _ = strconv.Atoi(stem[match[2]:match[3]])
+		page.last = page.first
+		if match[4] >= 0 {
+			page.last, _ = strconv.Atoi(stem[match[4]:match[5]])
+		}
+		prefix := stem[:match[0]]
+		sequences[prefix] = append(sequences[prefix], page)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pages := range sequences {
+		sort.SliceStable(pages, func(i, j int) bool { return pages[i].first < pages[j].first })
+		for i := 1; i < len(pages); i++ {
+			prev, page := pages[i-1], pages[i]
+			switch missing := page.first - prev.last - 1; {
+			case missing == 1:
+				issues = append(issues, ValidationIssue{Page: page.name, Message: fmt.Sprintf("page %d is missing", prev.last+1)})
+			case missing > 1:
+				issues = append(issues, ValidationIssue{Page: page.name, Message: fmt.Sprintf("pages %d-%d are missing", prev.last+1, page.first-1)})
+			}
+		}
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		return naturalLess(issues[i].Page, issues[j].Page)
+	})
+	return issues, nil
+}
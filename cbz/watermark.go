@@ -0,0 +1,72 @@
+package cbz
+
+import (
+	"embed"
+	"fmt"
+	"image"
+	"image/color"
+	"log/slog"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// defaultWatermarkOpacity is used when StripOptions.WatermarkOpacity is zero.
+const defaultWatermarkOpacity = 0.5
+
+//go:embed fonts/Go-Mono.ttf
+var fonts embed.FS
+
+var watermarkFont = sync.OnceValues(func() (*opentype.Font, error) {
+	data, err := fonts.ReadFile("fonts/Go-Mono.ttf")
+	if err != nil {
+		return nil, err
+	}
+	return opentype.Parse(data)
+})
+
+// drawWatermark renders text in the bottom-right corner of area, alpha
+// blended onto img at the given opacity and clipped to img's bounds. The font
+// size follows the width of area so the mark stays legible on both narrow and
+// wide strips.
+func drawWatermark(img *image.RGBA, area image.Rectangle, text string, opacity float64) error {
+	f, err := watermarkFont()
+	if err != nil {
+		return fmt.Errorf("error loading watermark font: %v", err)
+	}
+
+	size := max(12, float64(area.Dx())/20)
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return fmt.Errorf("error loading watermark font: %v", err)
+	}
+	defer face.Close()
+
+	drawer := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(color.NRGBA{A: uint8(opacity*255 + 0.5)}),
+		Face: face,
+	}
+
+	margin := fixed.I(int(size / 2))
+	x := max(fixed.I(area.Min.X), fixed.I(area.Max.X)-margin-drawer.MeasureString(text))
+	y := fixed.I(area.Max.Y) - margin - face.Metrics().Descent
+	drawer.Dot = fixed.Point26_6{X: x, Y: y}
+	drawer.DrawString(text)
+	return nil
+}
+
+// applyWatermark draws the watermark described by opts in the corner of area,
+// logging rather than failing if the font cannot be loaded.
+func applyWatermark(img *image.RGBA, area image.Rectangle, opts StripOptions) {
+	opacity := opts.WatermarkOpacity
+	if opacity == 0 {
+		opacity = defaultWatermarkOpacity
+	}
+
+	if err := drawWatermark(img, area, opts.Watermark, opacity); err != nil {
+		slog.Error("Error drawing watermark", "error", err)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/alexander-bruun/go-cbz-to-png/cbz"
+)
+
+// outputFormats maps output file extensions to cbz.Encode formats.
+var outputFormats = map[string]string{
+	".png":  "png",
+	".jpg":  "jpeg",
+	".jpeg": "jpeg",
+	".webp": "webp",
+	".pdf":  "pdf",
+}
+
+// convertFile writes the strip for the archive at input to output, choosing
+// the encoding from output's extension.
+func convertFile(ctx context.Context, input, output string) error {
+	format, ok := outputFormats[strings.ToLower(filepath.Ext(output))]
+	if !ok {
+		return fmt.Errorf("unsupported output extension: %q", filepath.Ext(output))
+	}
+
+	img, _, err := cbz.CreateWebtoonStrip(ctx, input, cbz.StripOptions{AutoRotate: true})
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("error creating output file: %v", err)
+	}
+
+	if err := cbz.Encode(file, img, format, defaultQuality); err != nil {
+		file.Close()
+		return fmt.Errorf("error encoding %s: %v", output, err)
+	}
+	return file.Close()
+}
+
+// batchResult tallies the outcome of a batch conversion.
+type batchResult struct {
+	processed, skipped, errored int
+}
+
+// batchOutput returns the PNG convertDir writes the archive named name,
+// relative to the input directory, to: the same relative path under
+// outputDir with a .png extension.
+func batchOutput(outputDir, name string) string {
+	stem := strings.TrimSuffix(filepath.FromSlash(name), filepath.Ext(name))
+	return filepath.Join(outputDir, stem+".png")
+}
+
+// convertDir converts every archive under inputDir to a PNG at the same
+// relative path under outputDir, using up to workers concurrent
+// conversions. Archives whose output is newer than the archive are skipped.
+// Archives that would share an output, such as ch01.cbz and ch01.cbr, are
+// rejected before anything is converted. Once ctx is done no further
+// archives are started.
+func convertDir(ctx context.Context, inputDir, outputDir string, workers int) (batchResult, error) {
+	var result batchResult
+
+	archives, err := cbz.ListArchives(inputDir, math.MaxInt)
+	if err != nil {
+		return result, fmt.Errorf("error listing %s: %v", inputDir, err)
+	}
+
+	outputs := make(map[string]string, len(archives))
+	for _, archive := range archives {
+		output := batchOutput(outputDir, archive.Name)
+		if other, ok := outputs[output]; ok {
+			return result, fmt.Errorf("%s and %s would both be written to %s", other, archive.Name, output)
+		}
+		outputs[output] = archive.Name
+	}
+
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		return result, fmt.Errorf("error creating %s: %v", outputDir, err)
+	}
+
+	var mu sync.Mutex
+	jobs := make(chan cbz.ArchiveInfo)
+
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for archive := range jobs {
+				input := filepath.Join(inputDir, filepath.FromSlash(archive.Name))
+				output := batchOutput(outputDir, archive.Name)
+
+				if info, err := os.Stat(output); err == nil && info.ModTime().After(archive.Mtime) {
+					slog.Info("Skipping up-to-date archive", "file", archive.Name, "output", output)
+					mu.Lock()
+					result.skipped++
+					mu.Unlock()
+					continue
+				}
+
+				err := os.MkdirAll(filepath.Dir(output), 0o755)
+				if err == nil {
+					err = convertFile(ctx, input, output)
+				}
+
+				mu.Lock()
+				if err != nil {
+					slog.Error("Error converting archive", "file", archive.Name, "error", err)
+					result.errored++
+				} else {
+					slog.Info("Converted archive", "file", archive.Name, "output", output)
+					result.processed++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, archive := range archives {
+		select {
+		case jobs <- archive:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return result, ctx.Err()
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConvertDirKeepsRelativePaths(t *testing.T) {
+	input, output := t.TempDir(), t.TempDir()
+	page := testEntry{"001.png", pngPage(t, 20, 10)}
+	writeTestCBZ(t, input, "A/ch01.cbz", page)
+	writeTestCBZ(t, input, "B/ch01.cbz", page)
+
+	result, err := convertDir(context.Background(), input, output, 2)
+	if err != nil {
+		t.Fatalf("convertDir: %v", err)
+	}
+	if result.processed != 2 || result.errored != 0 {
+		t.Errorf("result = %+v, want 2 processed", result)
+	}
+	for _, name := range []string{"A/ch01.png", "B/ch01.png"} {
+		if _, err := os.Stat(filepath.Join(output, filepath.FromSlash(name))); err != nil {
+			t.Errorf("output %s: %v", name, err)
+		}
+	}
+}
+
+func TestConvertDirRejectsDuplicateOutputs(t *testing.T) {
+	input, output := t.TempDir(), t.TempDir()
+	page := testEntry{"001.png", pngPage(t, 20, 10)}
+	writeTestCBZ(t, input, "ch01.cbz", page)
+	writeTestCBZ(t, input, "ch01.cbt", page)
+
+	if _, err := convertDir(context.Background(), input, output, 1); err == nil {
+		t.Fatal("convertDir succeeded, want duplicate output error")
+	}
+	if entries, _ := os.ReadDir(output); len(entries) != 0 {
+		t.Errorf("convertDir wrote %d outputs before failing", len(entries))
+	}
+}
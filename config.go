@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/alexander-bruun/go-cbz-to-png/cbz"
+)
+
+// Config holds every setting of the server and the -cli and -batch modes.
+// Each field is named in JSON after the command-line flag that sets it, so
+// a -config file can hold any of them; flags given on the command line
+// override the file.
+type Config struct {
+	Addr            string   `json:"addr"`
+	Dir             string   `json:"dir"`
+	TLSCert         string   `json:"tls-cert"`
+	TLSKey          string   `json:"tls-key"`
+	TLSMinVersion   string   `json:"tls-min-version"`
+	Metrics         bool     `json:"metrics"`
+	ShutdownTimeout Duration `json:"shutdown-timeout"`
+
+	CLI       bool   `json:"cli"`
+	Input     string `json:"input"`
+	Output    string `json:"output"`
+	Batch     bool   `json:"batch"`
+	InputDir  string `json:"input-dir"`
+	OutputDir string `json:"output-dir"`
+	Workers   int    `json:"workers"`
+
+	Watch           bool  `json:"watch"`
+	Subdirs         bool  `json:"subdirs"`
+	MaxInputBytes   int64 `json:"max-input-bytes"`
+	MaxOutputPixels int   `json:"max-output-pixels"`
+
+	CORSOrigin     string  `json:"cors-origin"`
+	RateLimitRPS   float64 `json:"rate-limit-rps"`
+	RateLimitBurst int     `json:"rate-limit-burst"`
+	APIKey         string  `json:"api-key"`
+	SigningKey     string  `json:"signing-key"`
+
+	AllowRemote   bool     `json:"allow-remote"`
+	RemoteTimeout Duration `json:"remote-timeout"`
+
+	MaxConcurrentJobs int      `json:"max-concurrent-jobs"`
+	RejectOnBusy      bool     `json:"reject-on-busy"`
+	RequestTimeout    Duration `json:"request-timeout"`
+
+	Pprof     bool   `json:"pprof"`
+	PprofAddr string `json:"pprof-addr"`
+	LogFormat string `json:"log-format"`
+}
+
+// Duration is a time.Duration written in JSON as a string such as "30s".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\": %s", data)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// defaultConfig returns the settings used when neither a flag nor the
+// config file sets them, taking CBZ_DIR, CBZ_API_KEY and CBZ_SIGNING_KEY
+// from the environment.
+func defaultConfig() Config {
+	cfg := Config{
+		Addr:              defaultAddr,
+		Dir:               defaultDirectory,
+		TLSMinVersion:     "1.2",
+		ShutdownTimeout:   Duration(30 * time.Second),
+		Workers:           4,
+		MaxOutputPixels:   cbz.DefaultMaxOutputPixels,
+		RateLimitBurst:    10,
+		APIKey:            os.Getenv("CBZ_API_KEY"),
+		SigningKey:        os.Getenv("CBZ_SIGNING_KEY"),
+		RemoteTimeout:     Duration(30 * time.Second),
+		MaxConcurrentJobs: 4,
+		RequestTimeout:    Duration(60 * time.Second),
+		PprofAddr:         ":6060",
+		LogFormat:         "text",
+	}
+	if env := os.Getenv("CBZ_DIR"); env != "" {
+		cfg.Dir = env
+	}
+	return cfg
+}
+
+// registerFlags defines a flag on fs for every field of c, defaulting to its
+// current value.
+func (c *Config) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Addr, "addr", c.Addr, "address to listen on")
+	fs.StringVar(&c.Dir, "dir", c.Dir, "directory containing archives, relative to the working directory (overrides CBZ_DIR)")
+	fs.StringVar(&c.TLSCert, "tls-cert", c.TLSCert, "TLS certificate file; enables HTTPS together with -tls-key")
+	fs.StringVar(&c.TLSKey, "tls-key", c.TLSKey, "TLS private key file; enables HTTPS together with -tls-cert")
+	fs.StringVar(&c.TLSMinVersion, "tls-min-version", c.TLSMinVersion, "minimum TLS version (1.0, 1.1, 1.2 or 1.3)")
+	fs.BoolVar(&c.Metrics, "metrics", c.Metrics, "expose Prometheus metrics at /metrics")
+	fs.DurationVar((*time.Duration)(&c.ShutdownTimeout), "shutdown-timeout", time.Duration(c.ShutdownTimeout), "how long to wait for in-flight requests on shutdown")
+	fs.BoolVar(&c.CLI, "cli", c.CLI, "convert -input to -output and exit instead of starting the server")
+	fs.StringVar(&c.Input, "input", c.Input, "archive to convert in -cli mode")
+	fs.StringVar(&c.Output, "output", c.Output, "file to write in -cli mode; the extension selects png, jpeg, webp or pdf")
+	fs.BoolVar(&c.Batch, "batch", c.Batch, "convert every archive in -input-dir to PNGs in -output-dir and exit")
+	fs.StringVar(&c.InputDir, "input-dir", c.InputDir, "directory to convert in -batch mode")
+	fs.StringVar(&c.OutputDir, "output-dir", c.OutputDir, "directory to write PNGs to in -batch mode")
+	fs.IntVar(&c.Workers, "workers", c.Workers, "concurrent conversions in -batch mode")
+	fs.BoolVar(&c.Watch, "watch", c.Watch, "evict cached strips as soon as archives in -dir change")
+	fs.BoolVar(&c.Subdirs, "subdirs", c.Subdirs, "list archives in subdirectories of -dir too, by their relative path")
+	fs.Int64Var(&c.MaxInputBytes, "max-input-bytes", c.MaxInputBytes, "reject archives larger than this many bytes (0 for no limit)")
+	fs.IntVar(&c.MaxOutputPixels, "max-output-pixels", c.MaxOutputPixels, "reject strips with more pixels than this (negative for no limit)")
+	fs.StringVar(&c.CORSOrigin, "cors-origin", c.CORSOrigin, "allowed cross-origin requester, e.g. * or https://reader.example.com (disabled if empty)")
+	fs.Float64Var(&c.RateLimitRPS, "rate-limit-rps", c.RateLimitRPS, "requests per second allowed per client IP (0 disables rate limiting)")
+	fs.IntVar(&c.RateLimitBurst, "rate-limit-burst", c.RateLimitBurst, "burst size for -rate-limit-rps")
+	fs.StringVar(&c.APIKey, "api-key", c.APIKey, "require Authorization: Bearer <key> on every request (overrides CBZ_API_KEY)")
+	fs.StringVar(&c.SigningKey, "signing-key", c.SigningKey, "secret for signing time-limited /webtoon URLs handed out by /sign; requires -api-key (overrides CBZ_SIGNING_KEY)")
+	fs.BoolVar(&c.AllowRemote, "allow-remote", c.AllowRemote, "allow /webtoon to fetch http:// and https:// file URLs (exposes the server to SSRF)")
+	fs.DurationVar((*time.Duration)(&c.RemoteTimeout), "remote-timeout", time.Duration(c.RemoteTimeout), "timeout for fetching remote files with -allow-remote")
+	fs.IntVar(&c.MaxConcurrentJobs, "max-concurrent-jobs", c.MaxConcurrentJobs, "strips built at once; further requests wait (0 for no limit)")
+	fs.BoolVar(&c.RejectOnBusy, "reject-on-busy", c.RejectOnBusy, "answer 503 instead of waiting when -max-concurrent-jobs strips are already being built")
+	fs.DurationVar((*time.Duration)(&c.RequestTimeout), "request-timeout", time.Duration(c.RequestTimeout), "abort building a strip after this long with 504 Gateway Timeout (0 for no limit)")
+	fs.BoolVar(&c.Pprof, "pprof", c.Pprof, "serve net/http/pprof profiles, /healthz and /metrics on -pprof-addr (do not expose publicly)")
+	fs.StringVar(&c.PprofAddr, "pprof-addr", c.PprofAddr, "address of the admin server enabled by -pprof")
+	fs.StringVar(&c.LogFormat, "log-format", c.LogFormat, "log output format: text or json")
+}
+
+// loadConfigFile reads the JSON config file at path into c, which fs's
+// flags are bound to. Settings the file leaves out keep their value, and
+// flags already set on the command line keep theirs. Unknown settings are
+// rejected so typos do not go unnoticed.
+func (c *Config) loadConfigFile(fs *flag.FlagSet, path string) error {
+	set := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = f.Value.String()
+	})
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening config file: %v", err)
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("error parsing config file %s: %v", path, err)
+	}
+
+	for name, value := range set {
+		if err := fs.Set(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate reports every setting with an invalid value, naming each as its
+// flag.
+func (c *Config) validate() error {
+	var errs []error
+	if _, ok := tlsVersions[c.TLSMinVersion]; !ok {
+		errs = append(errs, fmt.Errorf("invalid tls-min-version %q: must be 1.0, 1.1, 1.2 or 1.3", c.TLSMinVersion))
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		errs = append(errs, fmt.Errorf("invalid log-format %q: must be text or json", c.LogFormat))
+	}
+	if c.Workers < 1 {
+		errs = append(errs, fmt.Errorf("invalid workers %d: must be at least 1", c.Workers))
+	}
+	if c.MaxInputBytes < 0 {
+		errs = append(errs, fmt.Errorf("invalid max-input-bytes %d: must not be negative", c.MaxInputBytes))
+	}
+	if c.RateLimitRPS < 0 {
+		errs = append(errs, fmt.Errorf("invalid rate-limit-rps %g: must not be negative", c.RateLimitRPS))
+	}
+	if c.RateLimitRPS > 0 && c.RateLimitBurst < 1 {
+		errs = append(errs, fmt.Errorf("invalid rate-limit-burst %d: must be at least 1", c.RateLimitBurst))
+	}
+	if c.MaxConcurrentJobs < 0 {
+		errs = append(errs, fmt.Errorf("invalid max-concurrent-jobs %d: must not be negative", c.MaxConcurrentJobs))
+	}
+	for _, d := range []struct {
+		name  string
+		value Duration
+	}{
+		{"shutdown-timeout", c.ShutdownTimeout},
+		{"remote-timeout", c.RemoteTimeout},
+		{"request-timeout", c.RequestTimeout},
+	} {
+		if d.value < 0 {
+			errs = append(errs, fmt.Errorf("invalid %s %v: must not be negative", d.name, time.Duration(d.value)))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,24 @@
+package main
+
+import "net/http"
+
+// withCORS allows cross-origin GET and POST requests from origin and answers
+// preflight requests directly.
+func withCORS(origin string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+		w.Header().Set("Access-Control-Max-Age", "86400")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Image-Width, X-Image-Height, X-Page-Count, X-Skipped-Pages, X-Skipped-Blank-Pages, X-Page-Widths, X-Sprite-Map")
+		if origin != "*" {
+			w.Header().Add("Vary", "Origin")
+		}
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
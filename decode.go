@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/alexander-bruun/go-cbz-to-png/cbz"
+)
+
+// maxDecodeUploadBytes caps the size of a request body to /decode.
+const maxDecodeUploadBytes = 20 << 20
+
+// decodeResult is the JSON response of /decode.
+type decodeResult struct {
+	Format string `json:"format,omitempty"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+	OK     bool   `json:"ok"`
+	Error  string `json:"error,omitempty"`
+}
+
+// handleDecode decodes a single uploaded image, sent as the "file" field of
+// a multipart form, the same way pages are decoded from archives, and
+// reports its format and dimensions or why it failed to decode. It helps
+// find out why a page is being skipped.
+func (s *Server) handleDecode(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxDecodeUploadBytes)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, fmt.Sprintf("Upload exceeds the %d byte limit", maxDecodeUploadBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Invalid upload: %v", err), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid upload: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// A small upload can claim an enormous image, so its header is checked
+	// against the output limit before any pixels are decoded.
+	var result decodeResult
+	maxPixels := s.maxOutputPixels
+	if maxPixels == 0 {
+		maxPixels = cbz.DefaultMaxOutputPixels
+	}
+	config, format, err := cbz.DecodeImageConfig(bytes.NewReader(data))
+	if err == nil && maxPixels > 0 && int64(config.Width)*int64(config.Height) > int64(maxPixels) {
+		err = fmt.Errorf("%w: %dx%d exceeds the %d pixel limit", cbz.ErrOutputTooLarge, config.Width, config.Height, maxPixels)
+		slog.InfoContext(r.Context(), "Uploaded image is too large to decode", "file", header.Filename, "error", err)
+		result = decodeResult{Format: format, Width: config.Width, Height: config.Height, Error: err.Error()}
+	} else if img, format, err := cbz.DecodeImage(bytes.NewReader(data)); err != nil {
+		slog.InfoContext(r.Context(), "Uploaded image failed to decode", "file", header.Filename, "error", err)
+		result.Error = err.Error()
+	} else {
+		bounds := img.Bounds()
+		result = decodeResult{Format: format, Width: bounds.Dx(), Height: bounds.Dy(), OK: true}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		slog.ErrorContext(r.Context(), "Error encoding decode result", "error", err)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// pngWithSize returns a 1x1 PNG whose header claims to be w by h, as a
+// decompression bomb would.
+func pngWithSize(t testing.TB, w, h uint32) []byte {
+	data := pngPage(t, 1, 1)
+	ihdr := data[8+4 : 8+4+4+13]
+	binary.BigEndian.PutUint32(ihdr[4:], w)
+	binary.BigEndian.PutUint32(ihdr[8:], h)
+	binary.BigEndian.PutUint32(data[8+4+4+13:], crc32.ChecksumIEEE(ihdr))
+	return data
+}
+
+func postDecode(t *testing.T, s http.Handler, data []byte) decodeResult {
+	t.Helper()
+	body, contentType := multipartFile(t, "page.png", data)
+	req := httptest.NewRequest(http.MethodPost, "/decode", body)
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	var result decodeResult
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestDecode(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	result := postDecode(t, s, pngPage(t, 30, 20))
+	if !result.OK || result.Format != "png" || result.Width != 30 || result.Height != 20 {
+		t.Errorf("result = %+v, want a 30x20 png", result)
+	}
+}
+
+func TestDecodeChecksSizeBeforeDecoding(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	result := postDecode(t, s, pngWithSize(t, 100_000, 100_000))
+	if result.OK || !strings.Contains(result.Error, "too large") {
+		t.Errorf("result = %+v, want a too large error", result)
+	}
+	if result.Width != 100_000 {
+		t.Errorf("width = %d, want the header's 100000", result.Width)
+	}
+}
+
+func TestWebtoonRejectsOversizedPage(t *testing.T) {
+	s, dir := newTestServer(t)
+	writeTestCBZ(t, dir, "bomb.cbz", testEntry{"001.png", pngWithSize(t, 100_000, 100_000)})
+
+	rec := serve(s, http.MethodGet, "/webtoon?file=bomb.cbz", nil)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want 413", rec.Code)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alexander-bruun/go-cbz-to-png/cbz"
+)
+
+// stripETag derives a strong ETag from the archive's identity and every
+// parameter that affects the encoded output, so it can be computed without
+// decoding any pages.
+func stripETag(filePath string, info os.FileInfo, opts cbz.StripOptions, format string, encodeOpts cbz.EncodeOptions) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%q|%d|%d|%s|%s|quality=%d compression=%d quantize=%t", filePath, info.ModTime().UnixNano(), info.Size(),
+		stripOptionsKey(opts), format, encodeOpts.Quality, encodeOpts.PNGCompression, encodeOpts.Quantize)
+	return fmt.Sprintf(`"%x"`, h.Sum(nil))
+}
+
+// etagMatches reports whether the request's If-None-Match header matches etag.
+func etagMatches(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// notModifiedSince reports whether the request's If-Modified-Since header is
+// at or after modTime. As RFC 9110 requires, the header is ignored when the
+// request also sends If-None-Match.
+func notModifiedSince(r *http.Request, modTime time.Time) bool {
+	if r.Header.Get("If-None-Match") != "" {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	// HTTP dates have a resolution of one second.
+	return !modTime.Truncate(time.Second).After(since)
+}
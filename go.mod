@@ -2,4 +2,31 @@ module github.com/alexander-bruun/go-cbz-to-png
 
 go 1.22.4
 
-require golang.org/x/image v0.18.0 // indirect
+require (
+	github.com/chai2010/webp v1.4.0
+	github.com/fsnotify/fsnotify v1.7.0
+	github.com/gen2brain/avif v0.4.0
+	github.com/jung-kurt/gofpdf v1.16.2
+	github.com/nwaples/rardecode/v2 v2.4.1
+	github.com/prometheus/client_golang v1.20.5
+	github.com/rwcarlsen/goexif v0.0.0-20190401172101-9e8deecbddbd
+	github.com/ultimate-guitar/go-imagequant v0.0.0-20201216103743-29e607cca148
+	golang.org/x/image v0.18.0
+	golang.org/x/net v0.27.0
+	golang.org/x/time v0.5.0
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/ebitengine/purego v0.8.1 // indirect
+	github.com/klauspost/compress v1.17.9 // indirect
+	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
+	github.com/prometheus/client_model v0.6.1 // indirect
+	github.com/prometheus/common v0.55.0 // indirect
+	github.com/prometheus/procfs v0.15.1 // indirect
+	github.com/tetratelabs/wazero v1.9.0 // indirect
+	golang.org/x/sys v0.22.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
+	google.golang.org/protobuf v1.34.2 // indirect
+)
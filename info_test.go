@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestInfoCountsSniffedPages(t *testing.T) {
+	s, dir := newTestServer(t)
+	writeTestCBZ(t, dir, "sniffed.cbz",
+		testEntry{"001.png", pngPage(t, 100, 80)},
+		testEntry{"002", pngPage(t, 100, 80)},
+		testEntry{"003.dat", pngPage(t, 100, 80)},
+		testEntry{"ComicInfo.xml", []byte("<ComicInfo/>")},
+	)
+
+	rec := serve(s, http.MethodGet, "/info?file=sniffed.cbz", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/info status = %d: %s", rec.Code, rec.Body)
+	}
+	var info struct {
+		PageCount int `json:"pageCount"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+
+	rec = serve(s, http.MethodGet, "/webtoon?file=sniffed.cbz", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/webtoon status = %d: %s", rec.Code, rec.Body)
+	}
+	stripPages, _ := strconv.Atoi(rec.Header().Get("X-Page-Count"))
+
+	if info.PageCount != 3 || info.PageCount != stripPages {
+		t.Errorf("/info counts %d pages and the strip %d, want 3 for both", info.PageCount, stripPages)
+	}
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/alexander-bruun/go-cbz-to-png/cbz"
+)
+
+// jobTTL is how long a finished job's result is kept.
+const jobTTL = 10 * time.Minute
+
+// maxJobs caps the jobs held at once, finished or not. Once it is reached
+// the oldest finished job makes way for a new one, and new jobs are
+// refused while every held job is still pending.
+const maxJobs = 1000
+
+// maxJobResultBytes caps the total size of the encoded strips held by
+// finished jobs. A job finishing over the budget evicts the oldest finished
+// jobs until its result fits; a result larger than the whole budget fails
+// its job instead.
+const maxJobResultBytes = 256 << 20
+
+// Job states reported by GET /jobs/{id}.
+const (
+	jobPending = "pending"
+	jobDone    = "done"
+	jobError   = "error"
+)
+
+// job is a strip being built in the background for POST /jobs.
+type job struct {
+	mu       sync.Mutex
+	status   string
+	progress float64
+	err      string
+	finished time.Time
+
+	result      []byte
+	contentType string
+	filename    string
+}
+
+// jobStatus is the JSON body of GET /jobs/{id}.
+type jobStatus struct {
+	Status   string  `json:"status"`
+	Progress float64 `json:"progress"`
+	Error    string  `json:"error,omitempty"`
+}
+
+func (j *job) snapshot() jobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return jobStatus{Status: j.status, Progress: j.progress, Error: j.err}
+}
+
+func (j *job) setProgress(progress float64) {
+	j.mu.Lock()
+	j.progress = progress
+	j.mu.Unlock()
+}
+
+// setFormat records the format the job's result is encoded in, which is
+// only known once the strip is built.
+func (j *job) setFormat(filename, format string) {
+	j.mu.Lock()
+	j.contentType = contentTypes[format]
+	j.filename = fmt.Sprintf("%s.%s", filepath.Base(filename), format)
+	j.mu.Unlock()
+}
+
+func (j *job) finish(result []byte, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.finished = time.Now()
+	if err != nil {
+		j.status = jobError
+		j.err = err.Error()
+		return
+	}
+	j.status = jobDone
+	j.progress = 1
+	j.result = result
+}
+
+// jobStore holds background jobs by ID until they expire.
+type jobStore struct {
+	mu          sync.Mutex
+	jobs        map[string]*job
+	maxBytes    int64
+	resultBytes int64
+}
+
+func newJobStore() *jobStore {
+	return &jobStore{jobs: make(map[string]*job), maxBytes: maxJobResultBytes}
+}
+
+// add stores j under a new ID. It returns false if the store is full of
+// pending jobs.
+func (s *jobStore) add(j *job) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.jobs) >= maxJobs {
+		s.expire(time.Now())
+	}
+	if len(s.jobs) >= maxJobs {
+		var oldestID string
+		var oldest time.Time
+		for id, j := range s.jobs {
+			j.mu.Lock()
+			finished := j.finished
+			j.mu.Unlock()
+			if !finished.IsZero() && (oldestID == "" || finished.Before(oldest)) {
+				oldestID, oldest = id, finished
+			}
+		}
+		if oldestID == "" {
+			return "", false
+		}
+		s.remove(oldestID)
+	}
+
+	id := newRequestID()
+	s.jobs[id] = j
+	return id, true
+}
+
+func (s *jobStore) get(id string) (*job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	return j, ok
+}
+
+// finish completes j with result or err, making room for result within
+// the store's byte budget by evicting the oldest finished jobs. It returns
+// the error j failed with, if any.
+func (s *jobStore) finish(j *job, result []byte, err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	size := int64(len(result))
+	if err == nil && size > s.maxBytes {
+		err = fmt.Errorf("result of %d bytes exceeds the %d byte limit for job results", size, s.maxBytes)
+	}
+	if err != nil {
+		j.finish(nil, err)
+		return err
+	}
+
+	for s.resultBytes+size > s.maxBytes {
+		var oldestID string
+		var oldest time.Time
+		for id, held := range s.jobs {
+			held.mu.Lock()
+			finished, hasResult := held.finished, held.result != nil
+			held.mu.Unlock()
+			if hasResult && (oldestID == "" || finished.Before(oldest)) {
+				oldestID, oldest = id, finished
+			}
+		}
+		if oldestID == "" {
+			break
+		}
+		s.remove(oldestID)
+	}
+
+	j.finish(result, nil)
+	s.resultBytes += size
+	return nil
+}
+
+// cleanup drops jobs that finished more than jobTTL ago every jobTTL/10,
+// until ctx is done.
+func (s *jobStore) cleanup(ctx context.Context) {
+	ticker := time.NewTicker(jobTTL / 10)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			s.expire(now)
+			s.mu.Unlock()
+		}
+	}
+}
+
+// expire drops jobs that finished more than jobTTL before now. s.mu must be
+// held.
+func (s *jobStore) expire(now time.Time) {
+	for id, j := range s.jobs {
+		j.mu.Lock()
+		expired := !j.finished.IsZero() && now.Sub(j.finished) > jobTTL
+		j.mu.Unlock()
+
+		if expired {
+			s.remove(id)
+		}
+	}
+}
+
+// remove drops the job stored under id, releasing its result from the byte
+// budget. s.mu must be held.
+func (s *jobStore) remove(id string) {
+	j := s.jobs[id]
+	j.mu.Lock()
+	s.resultBytes -= int64(len(j.result))
+	j.mu.Unlock()
+	delete(s.jobs, id)
+}
+
+// jobRequest is the JSON body of POST /jobs. Options takes the same names
+// and values as the /webtoon query parameters, such as "scale" or "gap".
+type jobRequest struct {
+	File    string         `json:"file"`
+	Options map[string]any `json:"options"`
+}
+
+// handleCreateJob starts building a strip in the background and returns its
+// job ID immediately.
+func (s *Server) handleCreateJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body jobRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// The body is translated into /webtoon query parameters so both
+	// endpoints share the same validation.
+	query := url.Values{}
+	query.Set("file", body.File)
+	for name, value := range body.Options {
+		query.Set(name, fmt.Sprint(value))
+	}
+	r = r.Clone(r.Context())
+	r.URL.RawQuery = query.Encode()
+
+	filename, filePath, ok := s.resolveArchivePath(w, r)
+	if !ok {
+		return
+	}
+
+	opts, err := parseStripOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts.MaxInputBytes = s.maxInputBytes
+	opts.MaxOutputPixels = s.maxOutputPixels
+
+	format, encodeOpts, err := parseOutputFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if isPagesFormat(format) {
+		http.Error(w, fmt.Sprintf("Format %s is not supported for jobs", format), http.StatusBadRequest)
+		return
+	}
+
+	j := &job{status: jobPending}
+	id, ok := s.jobs.add(j)
+	if !ok {
+		http.Error(w, "Too many pending jobs", http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+	go func() {
+		// Jobs always wait for a slot; rejecting them would only move the
+		// retry into the status poll.
+		s.waitJobSlot(ctx)
+		defer s.releaseJobSlot()
+
+		if s.requestTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, s.requestTimeout)
+			defer cancel()
+		}
+
+		// Decoding takes up most of the work, so it advances progress to
+		// decodeShare one page at a time and encoding completes it. A strip
+		// served from the cache skips straight to encoding.
+		const decodeShare = 0.9
+		decoded := 0
+		opts.OnPageDecoded = func(_, total int, _ image.Image) {
+			decoded++
+			j.setProgress(decodeShare * float64(decoded) / float64(total))
+		}
+
+		start := time.Now()
+		img, _, err := s.webtoonStrip(ctx, filePath, opts)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error creating webtoon strip", "job", id, "file", filename, "error", err)
+			s.jobs.finish(j, nil, err)
+			return
+		}
+		defer s.releaseStrip(img)
+		j.setProgress(decodeShare)
+
+		format, err := outputFormatFor(r, format, img.Bounds().Size())
+		if err != nil {
+			s.jobs.finish(j, nil, err)
+			return
+		}
+		j.setFormat(filename, format)
+
+		var buf bytes.Buffer
+		if err := cbz.EncodeWithOptions(&buf, img, format, encodeOpts); err != nil {
+			slog.ErrorContext(ctx, "Error encoding strip", "job", id, "file", filename, "error", err)
+			s.jobs.finish(j, nil, err)
+			return
+		}
+		s.jobs.finish(j, buf.Bytes(), nil)
+
+		slog.InfoContext(ctx, "Finished job", "job", id, "file", filename, "duration_ms", time.Since(start).Milliseconds())
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+id)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"jobId": id})
+}
+
+// handleJobStatus reports the status and progress of a job.
+func (s *Server) handleJobStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	j, ok := s.jobs.get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(j.snapshot()); err != nil {
+		slog.ErrorContext(r.Context(), "Error encoding job status", "error", err)
+	}
+}
+
+// handleJobResult serves the encoded strip of a finished job.
+func (s *Server) handleJobResult(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	j, ok := s.jobs.get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	j.mu.Lock()
+	status, result, errMsg := j.status, j.result, j.err
+	contentType, filename := j.contentType, j.filename
+	j.mu.Unlock()
+
+	switch status {
+	case jobPending:
+		http.Error(w, "Job is still pending", http.StatusConflict)
+		return
+	case jobError:
+		http.Error(w, fmt.Sprintf("Job failed: %s", errMsg), http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(result)))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", filename))
+	if _, err := w.Write(result); err != nil {
+		slog.ErrorContext(r.Context(), "Error streaming job result", "job", r.PathValue("id"), "error", err)
+	}
+}
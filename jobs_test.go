@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJobStoreExpiresFinishedJobs(t *testing.T) {
+	s := &jobStore{jobs: make(map[string]*job)}
+	finished := &job{status: jobDone, finished: time.Now().Add(-2 * jobTTL)}
+	pending := &job{status: jobPending}
+	finishedID, _ := s.add(finished)
+	pendingID, _ := s.add(pending)
+
+	s.mu.Lock()
+	s.expire(time.Now())
+	s.mu.Unlock()
+
+	if _, ok := s.get(finishedID); ok {
+		t.Error("job finished longer than jobTTL ago was kept")
+	}
+	if _, ok := s.get(pendingID); !ok {
+		t.Error("pending job was dropped")
+	}
+}
+
+func TestJobStoreCapped(t *testing.T) {
+	s := &jobStore{jobs: make(map[string]*job)}
+	oldestID, _ := s.add(&job{status: jobDone, finished: time.Now().Add(-time.Minute)})
+	for range maxJobs - 1 {
+		if _, ok := s.add(&job{status: jobPending}); !ok {
+			t.Fatal("add refused a job below the cap")
+		}
+	}
+
+	if _, ok := s.add(&job{status: jobPending}); !ok {
+		t.Fatal("add refused a job that could replace a finished one")
+	}
+	if _, ok := s.get(oldestID); ok {
+		t.Error("oldest finished job was not dropped")
+	}
+	if len(s.jobs) != maxJobs {
+		t.Errorf("store holds %d jobs, want %d", len(s.jobs), maxJobs)
+	}
+	if _, ok := s.add(&job{status: jobPending}); ok {
+		t.Error("add accepted a job while every held job is pending")
+	}
+}
+
+func TestJobProgress(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(`{"file":"ch1.cbz"}`))
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202: %s", rec.Code, rec.Body)
+	}
+	var created map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+
+	j, ok := s.jobs.get(created["jobId"])
+	if !ok {
+		t.Fatal("job not found")
+	}
+	var last float64
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		status := j.snapshot()
+		if status.Progress < last {
+			t.Fatalf("progress went back from %v to %v", last, status.Progress)
+		}
+		last = status.Progress
+		if status.Status != jobPending {
+			break
+		}
+	}
+	if status := j.snapshot(); status.Status != jobDone || status.Progress != 1 {
+		t.Errorf("job = %+v, want done with progress 1", status)
+	}
+}
+
+func TestJobStoreResultBudget(t *testing.T) {
+	s := &jobStore{jobs: make(map[string]*job), maxBytes: 100}
+	first, second := &job{status: jobPending}, &job{status: jobPending}
+	firstID, _ := s.add(first)
+	secondID, _ := s.add(second)
+
+	if err := s.finish(first, make([]byte, 60), nil); err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+	if err := s.finish(second, make([]byte, 60), nil); err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+	if _, ok := s.get(firstID); ok {
+		t.Error("oldest result was kept over the budget")
+	}
+	if _, ok := s.get(secondID); !ok {
+		t.Error("newest result was dropped")
+	}
+	if s.resultBytes != 60 {
+		t.Errorf("store holds %d result bytes, want 60", s.resultBytes)
+	}
+
+	huge := &job{status: jobPending}
+	s.add(huge)
+	if err := s.finish(huge, make([]byte, 101), nil); err == nil {
+		t.Error("finish accepted a result larger than the budget")
+	}
+	if status := huge.snapshot(); status.Status != jobError {
+		t.Errorf("oversized job status = %q, want error", status.Status)
+	}
+	if _, ok := s.get(secondID); !ok {
+		t.Error("an oversized result evicted other jobs")
+	}
+}
+
+func TestJobStoreCleanupStops(t *testing.T) {
+	s := newJobStore()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.cleanup(ctx)
+		close(done)
+	}()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("cleanup kept running after its context was canceled")
+	}
+}
+
+func TestJobTimeout(t *testing.T) {
+	s, _ := newTestServer(t, WithRequestTimeout(time.Nanosecond))
+
+	req := httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(`{"file":"ch1.cbz"}`))
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	var created map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+
+	j, _ := s.jobs.get(created["jobId"])
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline) && j.snapshot().Status == jobPending; {
+		time.Sleep(time.Millisecond)
+	}
+	if status := j.snapshot(); status.Status != jobError || !strings.Contains(status.Error, "deadline") {
+		t.Errorf("job = %+v, want failed with a deadline error", status)
+	}
+}
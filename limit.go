@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+)
+
+// busyRetryAfter is the Retry-After value, in seconds, sent when a strip is
+// rejected because every job slot is taken.
+const busyRetryAfter = 5
+
+// WithMaxConcurrentJobs limits how many strips are built at once to n, so a
+// burst of requests cannot exhaust memory. Further requests wait for a free
+// slot, or are rejected with 503 Service Unavailable when rejectOnBusy is
+// set.
+func WithMaxConcurrentJobs(n int, rejectOnBusy bool) Option {
+	return func(s *Server) {
+		s.jobSlots = make(chan struct{}, n)
+		s.rejectOnBusy = rejectOnBusy
+	}
+}
+
+// acquireJobSlot takes a job slot for the request, returning the function
+// that releases it. If the slot cannot be taken, because the server is busy
+// and rejects rather than waits or because the client went away, an error
+// response has been written and ok is false.
+func (s *Server) acquireJobSlot(w http.ResponseWriter, r *http.Request) (release func(), ok bool) {
+	if s.jobSlots == nil {
+		return func() {}, true
+	}
+
+	if s.rejectOnBusy {
+		select {
+		case s.jobSlots <- struct{}{}:
+			return s.releaseJobSlot, true
+		default:
+			w.Header().Set("Retry-After", strconv.Itoa(busyRetryAfter))
+			http.Error(w, "Server is busy", http.StatusServiceUnavailable)
+			return nil, false
+		}
+	}
+
+	if err := s.waitJobSlot(r.Context()); err != nil {
+		http.Error(w, "Request canceled", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	return s.releaseJobSlot, true
+}
+
+// waitJobSlot blocks until a job slot is free or ctx is done.
+func (s *Server) waitJobSlot(ctx context.Context) error {
+	if s.jobSlots == nil {
+		return nil
+	}
+
+	select {
+	case s.jobSlots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// releaseJobSlot frees a slot taken by acquireJobSlot or waitJobSlot.
+func (s *Server) releaseJobSlot() {
+	if s.jobSlots != nil {
+		<-s.jobSlots
+	}
+}
@@ -1,177 +1,1361 @@
 package main
 
 import (
-	"archive/zip"
 	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"image"
-	"image/draw"
-	"image/jpeg"
+	"image/color"
 	"image/png"
-	"io"
-	"log"
+	"log/slog"
+	"math"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path"
 	"path/filepath"
-	"sort"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
-	"golang.org/x/image/webp"
+	"github.com/alexander-bruun/go-cbz-to-png/cbz"
 )
 
 const (
-	port         = 8080
-	cbzDirectory = "./" // Directory where .cbz files are stored
+	defaultAddr           = ":8080"
+	defaultDirectory      = "./" // Directory where .cbz files are stored
+	defaultQuality        = 85
+	defaultThumbnailWidth = 200
+	maxThumbnailWidth     = 1024
+	thumbnailCacheBytes   = 64 << 20
+	defaultCacheBytes     = 512 << 20
+	defaultGridColumns    = 4
 )
 
+// contentTypes maps supported output formats to their MIME types.
+var contentTypes = map[string]string{
+	"png":  "image/png",
+	"jpeg": "image/jpeg",
+	"webp": "image/webp",
+	"pdf":  "application/pdf",
+	"zip":  "application/zip",
+
+	// multipart responses get their boundary appended.
+	"multipart": "multipart/mixed",
+}
+
+// tlsVersions maps -tls-min-version values to crypto/tls constants.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
 func main() {
-	http.HandleFunc("/webtoon", handleWebtoon)
+	cfg := defaultConfig()
+	cfg.registerFlags(flag.CommandLine)
+	configPath := flag.String("config", "", "JSON file of settings named after these flags; flags on the command line take precedence")
+	flag.Parse()
+
+	if *configPath != "" {
+		if err := cfg.loadConfigFile(flag.CommandLine, *configPath); err != nil {
+			fatal("Error loading config", "error", err)
+		}
+	}
+	if err := cfg.validate(); err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+
+	switch cfg.LogFormat {
+	case "text":
+		slog.SetDefault(slog.New(contextHandler{slog.NewTextHandler(os.Stderr, nil)}))
+	case "json":
+		slog.SetDefault(slog.New(contextHandler{slog.NewJSONHandler(os.Stderr, nil)}))
+	}
+
+	// Interrupting a conversion cancels the strips being decoded.
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	if cfg.Batch {
+		if cfg.InputDir == "" || cfg.OutputDir == "" {
+			fatal("-batch requires -input-dir and -output-dir")
+		}
+		result, err := convertDir(ctx, cfg.InputDir, cfg.OutputDir, cfg.Workers)
+		if err != nil {
+			fatal("Error converting directory", "error", err)
+		}
+		fmt.Printf("Processed %d, skipped %d, errored %d\n", result.processed, result.skipped, result.errored)
+		if result.errored > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if cfg.CLI {
+		if cfg.Input == "" || cfg.Output == "" {
+			fatal("-cli requires -input and -output")
+		}
+		if err := convertFile(ctx, cfg.Input, cfg.Output); err != nil {
+			fatal("Error converting archive", "file", cfg.Input, "error", err)
+		}
+		return
+	}
+
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		fatal("-tls-cert and -tls-key must be set together")
+	}
+
+	if cfg.SigningKey != "" && cfg.APIKey == "" {
+		fatal("-signing-key requires -api-key")
+	}
+
+	minVersion := tlsVersions[cfg.TLSMinVersion]
+
+	opts := []Option{
+		WithDirectory(cfg.Dir),
+		WithCache(defaultCacheBytes),
+		WithLimits(cfg.MaxInputBytes, cfg.MaxOutputPixels),
+	}
+	if cfg.Subdirs {
+		opts = append(opts, WithListDepth(math.MaxInt))
+	}
+	if cfg.Metrics {
+		opts = append(opts, WithMetrics())
+	}
+	if cfg.CORSOrigin != "" {
+		opts = append(opts, WithCORS(cfg.CORSOrigin))
+	}
+	if cfg.RateLimitRPS > 0 {
+		opts = append(opts, WithRateLimit(cfg.RateLimitRPS, cfg.RateLimitBurst))
+	}
+	if cfg.APIKey != "" {
+		opts = append(opts, WithAPIKey(cfg.APIKey))
+	}
+	if cfg.SigningKey != "" {
+		opts = append(opts, WithSigningKey(cfg.SigningKey))
+	}
+	if cfg.AllowRemote {
+		opts = append(opts, WithRemote(time.Duration(cfg.RemoteTimeout)))
+	}
+	if cfg.RequestTimeout > 0 {
+		opts = append(opts, WithRequestTimeout(time.Duration(cfg.RequestTimeout)))
+	}
+	if cfg.MaxConcurrentJobs > 0 {
+		opts = append(opts, WithMaxConcurrentJobs(cfg.MaxConcurrentJobs, cfg.RejectOnBusy))
+	}
+
+	server := NewServer(opts...)
+	go server.jobs.cleanup(ctx)
+	if cfg.Watch {
+		if err := server.watchArchives(ctx); err != nil {
+			fatal("Error watching archive directory", "dir", cfg.Dir, "error", err)
+		}
+	}
+	httpServer := &http.Server{
+		Addr:      cfg.Addr,
+		Handler:   server,
+		TLSConfig: &tls.Config{MinVersion: minVersion},
+	}
+
+	var adminServer *http.Server
+	if cfg.Pprof {
+		adminServer = &http.Server{Addr: cfg.PprofAddr, Handler: newAdminHandler(server)}
+		go func() {
+			slog.Info("Admin server starting", "addr", cfg.PprofAddr)
+			if err := adminServer.ListenAndServe(); err != http.ErrServerClosed {
+				fatal("Admin server failed", "error", err)
+			}
+		}()
+	}
+
+	go func() {
+		var err error
+		if cfg.TLSCert != "" {
+			slog.Info("Server starting", "addr", cfg.Addr, "dir", cfg.Dir, "tls", true)
+			err = httpServer.ListenAndServeTLS(cfg.TLSCert, cfg.TLSKey)
+		} else {
+			slog.Info("Server starting", "addr", cfg.Addr, "dir", cfg.Dir, "tls", false)
+			err = httpServer.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			fatal("Server failed", "error", err)
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-stop
+
+	slog.Info("Draining requests", "signal", sig.String(), "timeout", time.Duration(cfg.ShutdownTimeout))
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout))
+	defer cancelShutdown()
+
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		fatal("Error shutting down server", "error", err)
+	}
+	if adminServer != nil {
+		adminServer.Close()
+	}
+	slog.Info("Server stopped")
+}
+
+// fatal logs msg at error level and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// Server serves webtoon strips and pages over HTTP.
+type Server struct {
+	mux        *http.ServeMux
+	dir        string
+	cache      *stripCache
+	thumbnails *thumbnailCache
+	listDepth  int
+	metrics    bool
+
+	maxInputBytes   int64
+	maxOutputPixels int
+	requestTimeout  time.Duration
+
+	corsOrigin   string
+	apiKey       string
+	signingKey   string
+	rateLimiter  *ipRateLimiter
+	remoteClient *http.Client
+	jobs         *jobStore
+	jobSlots     chan struct{}
+	rejectOnBusy bool
+	handler      http.Handler
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithCache enables an in-memory LRU cache of composed strips holding at most
+// maxBytes of pixel data.
+func WithCache(maxBytes int64) Option {
+	return func(s *Server) {
+		s.cache = newStripCache(maxBytes)
+	}
+}
+
+// WithDirectory sets the directory archives are served from.
+func WithDirectory(dir string) Option {
+	return func(s *Server) {
+		s.dir = dir
+	}
+}
+
+// WithListDepth sets how many levels of subdirectories below the archive
+// directory the /list endpoint and OPDS feed descend into. The default of 0
+// lists only the top level; -subdirs lifts the limit.
+func WithListDepth(depth int) Option {
+	return func(s *Server) {
+		s.listDepth = depth
+	}
+}
+
+// WithLimits rejects archives larger than maxInputBytes and strips larger than
+// maxOutputPixels with 413 Request Entity Too Large. See cbz.StripOptions for
+// the meaning of zero and negative values.
+func WithLimits(maxInputBytes int64, maxOutputPixels int) Option {
+	return func(s *Server) {
+		s.maxInputBytes = maxInputBytes
+		s.maxOutputPixels = maxOutputPixels
+	}
+}
+
+// WithCORS sets the Access-Control-Allow-Origin sent on every response,
+// such as "*" or "https://reader.example.com".
+func WithCORS(origin string) Option {
+	return func(s *Server) {
+		s.corsOrigin = origin
+	}
+}
+
+// WithAPIKey requires every request to authenticate with
+// "Authorization: Bearer <key>".
+func WithAPIKey(key string) Option {
+	return func(s *Server) {
+		s.apiKey = key
+	}
+}
+
+// WithRateLimit limits each client IP to rps requests per second with bursts
+// of up to burst requests.
+func WithRateLimit(rps float64, burst int) Option {
+	return func(s *Server) {
+		s.rateLimiter = newIPRateLimiter(rps, burst)
+	}
+}
+
+// WithMetrics exposes Prometheus metrics at /metrics.
+func WithMetrics() Option {
+	return func(s *Server) {
+		s.metrics = true
+	}
+}
+
+// WithRequestTimeout aborts building a strip that takes longer than timeout
+// with 504 Gateway Timeout.
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.requestTimeout = timeout
+	}
+}
+
+// NewServer returns a Server with its routes registered.
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		mux:        http.NewServeMux(),
+		dir:        defaultDirectory,
+		jobs:       newJobStore(),
+		thumbnails: newThumbnailCache(thumbnailCacheBytes),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.mux.HandleFunc("/healthz", s.handleHealthz)
+	s.mux.HandleFunc("/readyz", s.handleReadyz)
+	s.mux.HandleFunc("/webtoon", instrument(s.handleWebtoon))
+	s.mux.HandleFunc("/page", s.handlePage)
+	s.mux.HandleFunc("/raw", s.handleRaw)
+	s.mux.HandleFunc("/thumbnail", s.handleThumbnail)
+	s.mux.HandleFunc("/list", s.handleList)
+	s.mux.HandleFunc("/info", s.handleInfo)
+	s.mux.HandleFunc("/manifest", s.handleManifest)
+	s.mux.HandleFunc("/quality", s.handleQuality)
+	s.mux.HandleFunc("/validate", s.handleValidate)
+	s.mux.HandleFunc("/decode", s.handleDecode)
+	s.mux.HandleFunc("/optimize", s.handleOptimize)
+	s.mux.HandleFunc("/opds", s.handleOPDS)
+	s.mux.HandleFunc("/opds/entry", s.handleOPDSEntry)
+	s.mux.HandleFunc("/reader", s.handleReader)
+	s.mux.HandleFunc("/ws/webtoon", s.handleWebSocket)
+	s.mux.HandleFunc("/jobs", s.handleCreateJob)
+	s.mux.HandleFunc("/jobs/{id}", s.handleJobStatus)
+	s.mux.HandleFunc("/jobs/{id}/result", s.handleJobResult)
+	s.mux.HandleFunc("/progress", s.handleProgress)
+
+	if s.metrics {
+		s.mux.Handle("/metrics", metricsHandler())
+	}
+	if s.signingKey != "" {
+		s.mux.HandleFunc("/sign", s.handleSign)
+	}
+
+	s.handler = s.mux
+	if s.rateLimiter != nil {
+		s.handler = withRateLimit(s.rateLimiter, s.handler)
+	}
+	if s.apiKey != "" {
+		s.handler = withAPIKey(s.apiKey, s.signingKey != "", s.handler)
+	}
+	if s.corsOrigin != "" {
+		s.handler = withCORS(s.corsOrigin, s.handler)
+	}
+	s.handler = withRequestID(s.handler)
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// handleHealthz reports that the server is running.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeStatus(w, http.StatusOK, "ok")
+}
 
-	log.Printf("Server starting on port %d...\n", port)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), nil))
+// handleReadyz reports whether the archive directory can be read.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if _, err := os.ReadDir(s.dir); err != nil {
+		slog.ErrorContext(r.Context(), "Readiness check failed", "dir", s.dir, "error", err)
+		writeStatus(w, http.StatusServiceUnavailable, "unavailable")
+		return
+	}
+	writeStatus(w, http.StatusOK, "ok")
 }
 
-func handleWebtoon(w http.ResponseWriter, r *http.Request) {
+func writeStatus(w http.ResponseWriter, code int, status string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"status": status})
+}
+
+func (s *Server) handleWebtoon(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.verifySignedURL(w, r) {
+		return
+	}
+
+	opts, err := parseStripOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts.MaxInputBytes = s.maxInputBytes
+	opts.MaxOutputPixels = s.maxOutputPixels
+
+	format, encodeOpts, err := parseOutputFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Add("Vary", "Accept")
+	spriteSheet := strings.ToLower(r.URL.Query().Get("format")) == "spritesheet"
+
+	var filename, filePath string
+	var filePaths []string
+	var ok bool
+	files := r.URL.Query().Get("files")
+	upload := r.Method == http.MethodPost
+	remote := !upload && isRemoteFile(r.URL.Query().Get("file"))
+
+	// Uploads and remote files are only received once the request is known
+	// to be valid and a job slot is free, so queued or rejected requests
+	// never fill the temp directory.
+	if upload || remote {
+		release, ok := s.acquireJobSlot(w, r)
+		if !ok {
+			return
+		}
+		defer release()
+	}
+
+	switch {
+	case upload:
+		var cleanup func()
+		filename, filePath, cleanup, ok = s.receiveUploadedArchive(w, r)
+		if ok {
+			defer cleanup()
+		}
+	case files != "":
+		filename, filePaths, ok = s.resolveArchivePaths(w, files)
+	case remote:
+		var cleanup func()
+		filename, filePath, cleanup, ok = s.fetchRemoteArchive(w, r, r.URL.Query().Get("file"))
+		if ok {
+			defer cleanup()
+		}
+	default:
+		filename, filePath, ok = s.resolveArchivePath(w, r)
+	}
+	if !ok {
+		return
+	}
+
+	// Remote and uploaded files land in a fresh temp file on every request,
+	// so neither the ETag nor the cache would ever match them.
+	if len(filePaths) == 0 && !remote && !upload && !isPagesFormat(format) {
+		info, statErr := os.Stat(filePath)
+		if statErr != nil {
+			slog.ErrorContext(r.Context(), "Error reading file info", "file", filename, "error", statErr)
+			http.Error(w, "Error reading file", http.StatusInternalServerError)
+			return
+		}
+
+		etagFormat := format
+		if spriteSheet {
+			etagFormat = "spritesheet"
+		}
+		etag := stripETag(filePath, info, opts, etagFormat, encodeOpts)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+		if etagMatches(r, etag) || notModifiedSince(r, info.ModTime()) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	if !upload && !remote {
+		release, ok := s.acquireJobSlot(w, r)
+		if !ok {
+			return
+		}
+		defer release()
+	}
+
+	if s.requestTimeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), s.requestTimeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+
+	if isPagesFormat(format) {
+		if len(filePaths) == 0 {
+			filePaths = []string{filePath}
+		}
+		if format == "multipart" {
+			s.servePagesMultipart(w, r, filename, filePaths, opts, encodeOpts)
+		} else {
+			s.servePagesZip(w, r, filename, filePaths, opts, encodeOpts)
+		}
+		return
+	}
+
+	var img image.Image
+	var result cbz.StripResult
+	switch {
+	case len(filePaths) > 0:
+		img, result, err = createCombinedStrip(r.Context(), filePaths, opts)
+	case remote || upload:
+		img, result, err = createWebtoonStrip(r.Context(), filePath, opts)
+	default:
+		img, result, err = s.webtoonStrip(r.Context(), filePath, opts)
+	}
+	if err != nil {
+		writeStripError(w, r, filename, err)
+		return
+	}
+	if len(filePaths) > 0 || remote || upload {
+		defer cbz.ReleaseStrip(img)
+	} else {
+		defer s.releaseStrip(img)
+	}
+
+	bounds := img.Bounds()
+	if format, err = outputFormatFor(r, format, bounds.Size()); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("X-Image-Width", strconv.Itoa(bounds.Dx()))
+	w.Header().Set("X-Image-Height", strconv.Itoa(bounds.Dy()))
+	w.Header().Set("X-Page-Count", strconv.Itoa(result.PageCount))
+	w.Header().Set("X-Skipped-Pages", strconv.Itoa(result.SkippedPages))
+	w.Header().Set("X-Skipped-Blank-Pages", strconv.Itoa(result.SkippedBlankPages))
+	w.Header().Set("X-Page-Widths", joinInts(result.PageWidths))
+	if spriteSheet {
+		spriteMap, err := encodeSpriteMap(result.PageRects)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error encoding sprite map", "file", filename, "error", err)
+			http.Error(w, "Error encoding sprite map", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("X-Sprite-Map", spriteMap)
+	}
+	w.Header().Set("Content-Type", contentTypes[format])
+	suffix := ""
+	if opts.RightToLeft {
+		suffix = "_rtl"
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s%s.%s\"", filepath.Base(filename), suffix, format))
+
+	err = writeImage(w, img, format, encodeOpts)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error streaming strip", "file", filename, "format", format, "error", err)
+		http.Error(w, "Error sending image", http.StatusInternalServerError)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Served webtoon strip",
+		"file", filename,
+		"format", format,
+		"width", bounds.Dx(),
+		"height", bounds.Dy(),
+		"duration_ms", time.Since(start).Milliseconds(),
+	)
+}
+
+// writeStripError reports an error from building a strip with the status
+// code matching its cause.
+func writeStripError(w http.ResponseWriter, r *http.Request, filename string, err error) {
+	switch {
+	case errors.Is(err, cbz.ErrInvalidPageRange) || errors.Is(err, cbz.ErrInvalidCropMargin) || errors.Is(err, cbz.ErrInvalidSortField):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, cbz.ErrFileTooLarge) || errors.Is(err, cbz.ErrOutputTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, cbz.ErrArchiveEncrypted):
+		http.Error(w, "Password-protected archives are not supported", http.StatusUnprocessableEntity)
+	case errors.Is(err, cbz.ErrArchiveTruncated) || errors.Is(err, cbz.ErrEPUBNotImages):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, context.DeadlineExceeded):
+		slog.WarnContext(r.Context(), "Timed out creating webtoon strip", "file", filename)
+		http.Error(w, "Timed out processing file", http.StatusGatewayTimeout)
+	default:
+		slog.ErrorContext(r.Context(), "Error creating webtoon strip", "file", filename, "error", err)
+		http.Error(w, fmt.Sprintf("Error processing file: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// joinInts formats values as a comma-separated list.
+func joinInts(values []int) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = strconv.Itoa(v)
+	}
+	return strings.Join(parts, ",")
+}
+
+// writeImage encodes img into memory first so the response can carry a
+// Content-Length header, then writes it to w.
+func writeImage(w http.ResponseWriter, img image.Image, format string, opts cbz.EncodeOptions) error {
+	var buf bytes.Buffer
+	if err := cbz.EncodeWithOptions(&buf, img, format, opts); err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// webtoonStrip returns the strip for filePath, consulting the cache when one
+// is configured.
+func (s *Server) webtoonStrip(ctx context.Context, filePath string, opts cbz.StripOptions) (image.Image, cbz.StripResult, error) {
+	if s.cache == nil {
+		return createWebtoonStrip(ctx, filePath, opts)
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, cbz.StripResult{}, err
+	}
+
+	key := newStripCacheKey(filePath, opts)
+	if img, result, ok := s.cache.Get(key, info.ModTime()); ok {
+		cacheHits.Inc()
+		return img, result, nil
+	}
+	cacheMisses.Inc()
+
+	img, result, err := createWebtoonStrip(ctx, filePath, opts)
+	if err != nil {
+		return nil, result, err
+	}
+
+	s.cache.Add(key, info.ModTime(), img, result)
+	return img, result, nil
+}
+
+// releaseStrip returns the buffer of a strip from webtoonStrip to the pool
+// once it has been encoded, unless the cache may still hold it.
+func (s *Server) releaseStrip(img image.Image) {
+	if s.cache == nil {
+		cbz.ReleaseStrip(img)
+	}
+}
+
+// createWebtoonStrip is cbz.CreateWebtoonStrip, recording the page count.
+func createWebtoonStrip(ctx context.Context, filePath string, opts cbz.StripOptions) (image.Image, cbz.StripResult, error) {
+	pages, result, err := cbz.OpenStrip(ctx, filePath, opts)
+	if err != nil {
+		return nil, result, err
+	}
+	stripPages.Observe(float64(len(pages)))
+	slog.InfoContext(ctx, "Composed webtoon strip", "file", filePath, "pages", len(pages), "skipped", result.SkippedPages)
+
+	return cbz.CompositeStrip(pages, opts), result, nil
+}
+
+// createCombinedStrip builds one strip from the pages of several archives in
+// order, recording the page count.
+func createCombinedStrip(ctx context.Context, filePaths []string, opts cbz.StripOptions) (image.Image, cbz.StripResult, error) {
+	pages, result, err := cbz.OpenStrips(ctx, filePaths, opts)
+	if err != nil {
+		return nil, result, err
+	}
+	stripPages.Observe(float64(len(pages)))
+	slog.InfoContext(ctx, "Composed webtoon strip", "files", len(filePaths), "pages", len(pages), "skipped", result.SkippedPages)
+
+	return cbz.CompositeStrip(pages, opts), result, nil
+}
+
+// handlePage serves a single decoded page. The page query parameter is
+// 0-indexed into the naturally sorted list of images in the archive.
+func (s *Server) handlePage(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	filename := r.URL.Query().Get("file")
-	if filename == "" {
-		http.Error(w, "File parameter is required", http.StatusBadRequest)
+	filename, filePath, ok := s.resolveArchivePath(w, r)
+	if !ok {
 		return
 	}
 
-	if filepath.Ext(filename) != ".cbz" {
-		http.Error(w, "Invalid file extension. Only .cbz files are allowed", http.StatusBadRequest)
+	index, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || index < 0 {
+		http.Error(w, "Page parameter must be a non-negative integer", http.StatusBadRequest)
 		return
 	}
 
-	filePath := filepath.Join(cbzDirectory, filepath.Clean(filename))
+	format, encodeOpts, err := parseOutputFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if isPagesFormat(format) {
+		http.Error(w, fmt.Sprintf("Format %s is only supported for strips", format), http.StatusBadRequest)
+		return
+	}
+	w.Header().Add("Vary", "Accept")
 
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		http.Error(w, "File not found", http.StatusNotFound)
+	img, _, err := cbz.ExtractPage(filePath, index)
+	if err == cbz.ErrPageOutOfRange {
+		http.Error(w, "Page not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, cbz.ErrArchiveEncrypted) {
+		http.Error(w, "Password-protected archives are not supported", http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error extracting page", "file", filename, "page", index, "error", err)
+		http.Error(w, fmt.Sprintf("Error processing file: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if format, err = outputFormatFor(r, format, img.Bounds().Size()); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s-%d.%s\"", filepath.Base(filename), index, format))
+
+	err = writeImage(w, img, format, encodeOpts)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error streaming page", "file", filename, "page", index, "format", format, "error", err)
+		http.Error(w, "Error sending image", http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleRaw serves the original bytes of a single page, without decoding or
+// re-encoding it.
+func (s *Server) handleRaw(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filename, filePath, ok := s.resolveArchivePath(w, r)
+	if !ok {
+		return
+	}
+
+	index, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || index < 0 {
+		http.Error(w, "Page parameter must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+
+	name, data, err := cbz.ExtractRawPage(filePath, index)
+	if err == cbz.ErrPageOutOfRange {
+		http.Error(w, "Page not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, cbz.ErrArchiveEncrypted) {
+		http.Error(w, "Password-protected archives are not supported", http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error extracting page", "file", filename, "page", index, "error", err)
+		http.Error(w, fmt.Sprintf("Error processing file: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	img, err := CreateWebtoonStrip(filePath)
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", path.Base(name)))
+
+	if _, err := w.Write(data); err != nil {
+		slog.ErrorContext(r.Context(), "Error streaming page", "file", filename, "page", index, "error", err)
+	}
+}
+
+// handleThumbnail serves a JPEG preview of a single page, scaled down to the
+// width query parameter. Widths beyond maxThumbnailWidth are clamped to it,
+// so clients cannot fill the thumbnail cache with arbitrary sizes.
+func (s *Server) handleThumbnail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filename, filePath, ok := s.resolveArchivePath(w, r)
+	if !ok {
+		return
+	}
+
+	index, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || index < 0 {
+		http.Error(w, "Page parameter must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+
+	maxWidth := defaultThumbnailWidth
+	if v := r.URL.Query().Get("width"); v != "" {
+		maxWidth, err = strconv.Atoi(v)
+		if err != nil || maxWidth < 1 {
+			http.Error(w, "Width parameter must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+	maxWidth = min(maxWidth, maxThumbnailWidth)
+
+	img, err := s.thumbnail(filePath, index, maxWidth)
+	if err == cbz.ErrPageOutOfRange {
+		http.Error(w, "Page not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, cbz.ErrArchiveEncrypted) {
+		http.Error(w, "Password-protected archives are not supported", http.StatusUnprocessableEntity)
+		return
+	}
 	if err != nil {
-		log.Printf("Error creating webtoon strip: %v", err)
+		slog.ErrorContext(r.Context(), "Error creating thumbnail", "file", filename, "page", index, "error", err)
 		http.Error(w, fmt.Sprintf("Error processing file: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "image/png")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s.png\"", filepath.Base(filename)))
+	w.Header().Set("Content-Type", contentTypes["jpeg"])
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s-%d-thumb.jpeg\"", filepath.Base(filename), index))
 
-	err = streamPNG(w, img)
+	err = writeImage(w, img, "jpeg", cbz.EncodeOptions{Quality: defaultQuality})
 	if err != nil {
-		log.Printf("Error streaming PNG: %v", err)
+		slog.ErrorContext(r.Context(), "Error streaming thumbnail", "file", filename, "page", index, "error", err)
 		http.Error(w, "Error sending image", http.StatusInternalServerError)
 		return
 	}
 }
 
-func CreateWebtoonStrip(cbzFilePath string) (image.Image, error) {
-	reader, err := zip.OpenReader(cbzFilePath)
+// thumbnail returns the thumbnail of the page at index, consulting the
+// thumbnail cache.
+func (s *Server) thumbnail(filePath string, index, maxWidth int) (image.Image, error) {
+	info, err := os.Stat(filePath)
 	if err != nil {
-		return nil, fmt.Errorf("error opening CBZ file: %v", err)
+		return nil, err
 	}
-	defer reader.Close()
 
-	sort.Slice(reader.File, func(i, j int) bool {
-		return reader.File[i].Name < reader.File[j].Name
-	})
+	key := thumbnailKey{path: filePath, index: index, width: maxWidth}
+	if img, ok := s.thumbnails.Get(key, info.ModTime()); ok {
+		return img, nil
+	}
 
-	var images []image.Image
-	var totalHeight int
-	var commonWidth int
+	img, err := cbz.CreateThumbnail(filePath, index, maxWidth)
+	if err != nil {
+		return nil, err
+	}
+	s.thumbnails.Add(key, info.ModTime(), img)
+	return img, nil
+}
 
-	for _, file := range reader.File {
-		if isImageFile(file.Name) {
-			rc, err := file.Open()
-			if err != nil {
-				return nil, fmt.Errorf("error opening file %s: %v", file.Name, err)
-			}
+// handleList returns a JSON array describing the archives in the archive
+// directory.
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	files, err := cbz.ListArchives(s.dir, s.listDepth)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing archives", "dir", s.dir, "error", err)
+		http.Error(w, "Error listing files", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(files); err != nil {
+		slog.ErrorContext(r.Context(), "Error encoding file list", "error", err)
+	}
+}
+
+// archiveInfo is the JSON body of /info: the page summary plus the
+// archive's ComicInfo.xml metadata, if it has any. Title, Series and Width
+// are lifted out of the metadata for convenience, with Title and Width
+// inferred from the file name and pages when ComicInfo.xml is missing.
+type archiveInfo struct {
+	Title  string `json:"title"`
+	Series string `json:"series,omitempty"`
+	Width  int    `json:"width"`
+	cbz.ArchiveSummary
+	ComicInfo *cbz.ComicInfo `json:"comicInfo,omitempty"`
+}
+
+// handleInfo returns a JSON summary of the pages and metadata of an archive.
+func (s *Server) handleInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filename, filePath, ok := s.resolveArchivePath(w, r)
+	if !ok {
+		return
+	}
+
+	summary, err := cbz.SummarizeArchive(filePath)
+	if errors.Is(err, cbz.ErrArchiveEncrypted) {
+		http.Error(w, "Password-protected archives are not supported", http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error summarizing archive", "file", filePath, "error", err)
+		http.Error(w, fmt.Sprintf("Error processing file: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	info := archiveInfo{
+		Title:          strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename)),
+		Width:          summary.CommonWidth,
+		ArchiveSummary: summary,
+	}
+	info.ComicInfo, err = cbz.ReadComicInfo(filePath)
+	if err != nil && !errors.Is(err, cbz.ErrNoComicInfo) {
+		slog.ErrorContext(r.Context(), "Error reading ComicInfo.xml", "file", filePath, "error", err)
+	}
+	if info.ComicInfo != nil {
+		if info.ComicInfo.Title != "" {
+			info.Title = info.ComicInfo.Title
+		}
+		info.Series = info.ComicInfo.Series
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		slog.ErrorContext(r.Context(), "Error encoding archive info", "error", err)
+	}
+}
+
+// handleManifest returns the dimensions and format of every page in an
+// archive as a JSON array, without decoding any pixels.
+func (s *Server) handleManifest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, filePath, ok := s.resolveArchivePath(w, r)
+	if !ok {
+		return
+	}
+
+	manifest, err := cbz.CreateManifest(filePath)
+	if errors.Is(err, cbz.ErrArchiveEncrypted) {
+		http.Error(w, "Password-protected archives are not supported", http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading page manifest", "file", filePath, "error", err)
+		http.Error(w, fmt.Sprintf("Error processing file: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(manifest); err != nil {
+		slog.ErrorContext(r.Context(), "Error encoding page manifest", "error", err)
+	}
+}
+
+// resolveArchivePath validates the file query parameter and maps it into the
+// archive directory. On failure it writes an error response and returns false.
+func (s *Server) resolveArchivePath(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	filename := r.URL.Query().Get("file")
+	if filename == "" {
+		http.Error(w, "File parameter is required", http.StatusBadRequest)
+		return "", "", false
+	}
+
+	filePath, ok := s.archivePath(w, filename)
+	return filename, filePath, ok
+}
+
+// resolveArchivePaths validates the comma-separated files query parameter
+// like resolveArchivePath, returning a combined display name and the path of
+// each archive.
+func (s *Server) resolveArchivePaths(w http.ResponseWriter, files string) (string, []string, bool) {
+	var names, filePaths []string
+	for _, filename := range strings.Split(files, ",") {
+		filename = strings.TrimSpace(filename)
+		if filename == "" {
+			continue
+		}
+
+		filePath, ok := s.archivePath(w, filename)
+		if !ok {
+			return "", nil, false
+		}
+		names = append(names, filepath.Base(filename))
+		filePaths = append(filePaths, filePath)
+	}
+
+	if len(filePaths) == 0 {
+		http.Error(w, "Files parameter must list at least one file", http.StatusBadRequest)
+		return "", nil, false
+	}
+	return strings.Join(names, "+"), filePaths, true
+}
+
+// archivePath maps filename into the archive directory after checking its
+// extension and that it exists.
+func (s *Server) archivePath(w http.ResponseWriter, filename string) (string, bool) {
+	if !cbz.IsArchiveFile(filename) {
+		http.Error(w, "Invalid file extension. Only .cbz, .cbr, .cbt and .epub files are allowed", http.StatusBadRequest)
+		return "", false
+	}
+
+	// Names may point into subdirectories, such as Series/Volume1/ch01.cbz,
+	// but never outside the archive directory.
+	if !filepath.IsLocal(filename) {
+		http.Error(w, "Invalid file path", http.StatusBadRequest)
+		return "", false
+	}
+
+	filePath := filepath.Join(s.dir, filepath.Clean(filename))
+
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		http.Error(w, fmt.Sprintf("File not found: %s", filename), http.StatusNotFound)
+		return "", false
+	}
+
+	return filePath, true
+}
+
+// parseStripOptions builds StripOptions from the request's query parameters.
+func parseStripOptions(r *http.Request) (cbz.StripOptions, error) {
+	var opts cbz.StripOptions
+	query := r.URL.Query()
+
+	if err := parseBoolParam(query, "scale", &opts.ScaleToWidth); err != nil {
+		return opts, err
+	}
+
+	if v := query.Get("gap"); v != "" {
+		gap, err := strconv.Atoi(v)
+		if err != nil || gap < 0 {
+			return opts, fmt.Errorf("invalid gap parameter: %q", v)
+		}
+		opts.GapHeight = gap
+	}
+
+	if v := query.Get("gap-color"); v != "" {
+		c, err := parseHexColor(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid gap-color parameter: %v", err)
+		}
+		opts.GapColor = c
+	}
+
+	if v := query.Get("bg"); v != "" {
+		c, err := parseHexColor(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid bg parameter: %v", err)
+		}
+		opts.BackgroundColor = c
+	}
+
+	if err := parseBoolParam(query, "rtl", &opts.RightToLeft); err != nil {
+		return opts, err
+	}
 
-			data, err := io.ReadAll(rc)
-			rc.Close()
-			if err != nil {
-				return nil, fmt.Errorf("error reading file %s: %v", file.Name, err)
+	opts.AutoRotate = true
+	if err := parseBoolParam(query, "auto-rotate", &opts.AutoRotate); err != nil {
+		return opts, err
+	}
+
+	switch layout := query.Get("layout"); layout {
+	case "", "vertical":
+	case "horizontal":
+		opts.Horizontal = true
+	case "grid":
+		opts.GridColumns = defaultGridColumns
+		if v := query.Get("cols"); v != "" {
+			cols, err := strconv.Atoi(v)
+			if err != nil || cols < 1 {
+				return opts, fmt.Errorf("invalid cols parameter: %q", v)
 			}
+			opts.GridColumns = cols
+		}
+	default:
+		return opts, fmt.Errorf("invalid layout parameter: %q", layout)
+	}
+
+	switch field := query.Get("sort"); field {
+	case "", "name", "size", "mtime":
+		opts.SortField = field
+	default:
+		return opts, fmt.Errorf("invalid sort parameter: %q", field)
+	}
+	if err := parseBoolParam(query, "sort-desc", &opts.SortDescending); err != nil {
+		return opts, err
+	}
+
+	if v := query.Get("crop"); v != "" {
+		margin, err := strconv.Atoi(v)
+		if err != nil || margin < 0 {
+			return opts, fmt.Errorf("invalid crop parameter: %q must be a non-negative number of pixels", v)
+		}
+		opts.CropMargin = margin
+	}
+
+	if v := query.Get("max-width"); v != "" {
+		width, err := strconv.Atoi(v)
+		if err != nil || width < 1 {
+			return opts, fmt.Errorf("invalid max-width parameter: %q", v)
+		}
+		opts.MaxWidth = width
+	}
+
+	if v := query.Get("max-height"); v != "" {
+		height, err := strconv.Atoi(v)
+		if err != nil || height < 1 {
+			return opts, fmt.Errorf("invalid max-height parameter: %q", v)
+		}
+		opts.MaxHeight = height
+	}
+
+	if err := parseBoolParam(query, "split-spreads", &opts.SplitSpreads); err != nil {
+		return opts, err
+	}
 
-			img, format, err := decodeImage(bytes.NewReader(data))
-			if err != nil {
-				log.Printf("Error decoding file %s: %v", file.Name, err)
-				continue // Skip this file and try the next one
+	if err := parseBoolParam(query, "skip-blank", &opts.SkipBlankPages); err != nil {
+		return opts, err
+	}
+	if err := parseFloatParam(query, "blank-threshold", 0, 255, &opts.BlankThreshold); err != nil {
+		return opts, err
+	}
+	// cbz reads a zero threshold as DefaultBlankThreshold, while a client
+	// asking for 0 means every page with any light in it is blank, so it is
+	// rejected rather than silently replaced.
+	if v := query.Get("blank-threshold"); v != "" && opts.BlankThreshold == 0 {
+		return opts, fmt.Errorf("invalid blank-threshold parameter: %q must be above 0", v)
+	}
+
+	if err := parseBoolParam(query, "grayscale", &opts.Grayscale); err != nil {
+		return opts, err
+	}
+
+	if err := parseBoolParam(query, "invert", &opts.Invert); err != nil {
+		return opts, err
+	}
+
+	if err := parseFloatParam(query, "brightness", -1, 1, &opts.Brightness); err != nil {
+		return opts, err
+	}
+
+	if err := parseFloatParam(query, "contrast", 0, 3, &opts.Contrast); err != nil {
+		return opts, err
+	}
+
+	opts.Watermark = query.Get("watermark")
+	if err := parseFloatParam(query, "watermark-opacity", 0, 1, &opts.WatermarkOpacity); err != nil {
+		return opts, err
+	}
+
+	if v := query.Get("skip"); v != "" {
+		for _, field := range strings.Split(v, ",") {
+			page, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil || page < 0 {
+				return opts, fmt.Errorf("invalid skip parameter: %q", v)
 			}
+			opts.SkipPages = append(opts.SkipPages, page)
+		}
+	}
 
-			log.Printf("Successfully decoded %s as %s", file.Name, format)
+	start, end := query.Get("start"), query.Get("end")
+	if start != "" || end != "" {
+		opts.SelectRange = true
+		opts.EndPage = -1
 
-			width := img.Bounds().Dx()
-			if commonWidth == 0 {
-				commonWidth = width
-			} else if width != commonWidth {
-				log.Printf("Skipping %s: width %d doesn't match common width %d", file.Name, width, commonWidth)
-				continue
+		if start != "" {
+			page, err := strconv.Atoi(start)
+			if err != nil || page < 0 {
+				return opts, fmt.Errorf("invalid start parameter: %q", start)
 			}
+			opts.StartPage = page
+		}
 
-			images = append(images, img)
-			totalHeight += img.Bounds().Dy()
+		if end != "" {
+			page, err := strconv.Atoi(end)
+			if err != nil || page < 0 {
+				return opts, fmt.Errorf("invalid end parameter: %q", end)
+			}
+			opts.EndPage = page
 		}
 	}
 
-	if len(images) == 0 {
-		return nil, fmt.Errorf("no valid images found with matching width in the CBZ file")
+	return opts, nil
+}
+
+// parseBoolParam sets dst from the named query parameter if it is present.
+func parseBoolParam(query url.Values, name string, dst *bool) error {
+	v := query.Get(name)
+	if v == "" {
+		return nil
 	}
 
-	finalImage := image.NewRGBA(image.Rect(0, 0, commonWidth, totalHeight))
-	currentY := 0
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("invalid %s parameter: %q", name, v)
+	}
+	*dst = b
+	return nil
+}
 
-	for _, img := range images {
-		draw.Draw(finalImage, image.Rect(0, currentY, commonWidth, currentY+img.Bounds().Dy()), img, image.Point{}, draw.Src)
-		currentY += img.Bounds().Dy()
+// parseFloatParam parses the named query parameter into dst if present,
+// rejecting values outside [min, max].
+func parseFloatParam(query url.Values, name string, min, max float64, dst *float64) error {
+	v := query.Get(name)
+	if v == "" {
+		return nil
 	}
 
-	return finalImage, nil
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < min || f > max {
+		return fmt.Errorf("invalid %s parameter: %q", name, v)
+	}
+	*dst = f
+	return nil
 }
 
-func isImageFile(filename string) bool {
-	ext := strings.ToLower(filepath.Ext(filename))
-	return ext == ".jpg" || ext == ".jpeg" || ext == ".png" || ext == ".webp"
-}
+// parseOutputFormat reads the format, quality, png-compression and quantize
+// query parameters. Without a format parameter the Accept header is consulted.
+// Quality is clamped to 1-100 and only applies to lossy formats.
+func parseOutputFormat(r *http.Request) (string, cbz.EncodeOptions, error) {
+	query := r.URL.Query()
+	opts := cbz.EncodeOptions{Quality: defaultQuality}
 
-func decodeImage(r io.Reader) (image.Image, string, error) {
-	data, err := io.ReadAll(r)
-	if err != nil {
-		return nil, "", fmt.Errorf("error reading image data: %v", err)
+	format := strings.ToLower(query.Get("format"))
+	switch format {
+	case "":
+		format = negotiateFormat(r.Header.Get("Accept"))
+	case "jpg":
+		format = "jpeg"
+	case "spritesheet":
+		// Sprite sheets are PNG strips; /webtoon adds their page map.
+		format = "png"
+	}
+	if _, ok := contentTypes[format]; !ok {
+		return "", opts, fmt.Errorf("unsupported format: %q", format)
 	}
 
-	// Try decoding as JPEG
-	img, err := jpeg.Decode(bytes.NewReader(data))
-	if err == nil {
-		return img, "jpeg", nil
+	if v := query.Get("quality"); v != "" {
+		q, err := strconv.Atoi(v)
+		if err != nil {
+			return "", opts, fmt.Errorf("invalid quality parameter: %q", v)
+		}
+		opts.Quality = min(max(q, 1), 100)
 	}
 
-	// Try decoding as PNG
-	img, err = png.Decode(bytes.NewReader(data))
-	if err == nil {
-		return img, "png", nil
+	if v := query.Get("png-compression"); v != "" {
+		level, err := strconv.Atoi(v)
+		if err != nil || level < 0 || level > 9 {
+			return "", opts, fmt.Errorf("invalid png-compression parameter: %q", v)
+		}
+		opts.PNGCompression = pngCompressionLevel(level)
 	}
 
-	// Try decoding as WebP
-	img, err = webp.Decode(bytes.NewReader(data))
-	if err == nil {
-		return img, "webp", nil
+	if err := parseBoolParam(query, "quantize", &opts.Quantize); err != nil {
+		return "", opts, err
 	}
 
-	return nil, "", fmt.Errorf("unsupported image format")
+	return format, opts, nil
 }
 
-func streamPNG(w io.Writer, img image.Image) error {
-	encoder := png.Encoder{
-		CompressionLevel: png.DefaultCompression,
+// negotiateFormat picks the image format with the highest q-value in an
+// Accept header, preferring the earliest on ties. Wildcards and headers
+// naming no supported image type select PNG; documents and archives such
+// as pdf or zip are only served when the format parameter asks for them.
+func negotiateFormat(accept string) string {
+	format, best := "png", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q <= best {
+			continue
+		}
+
+		if mediaType == "*/*" || mediaType == "image/*" {
+			format, best = "png", q
+			continue
+		}
+		for name, contentType := range contentTypes {
+			if mediaType == contentType && strings.HasPrefix(contentType, "image/") {
+				format, best = name, q
+			}
+		}
+	}
+	return format
+}
+
+// outputFormatFor returns the format to encode an image of the given size
+// in. A format negotiated from the Accept header falls back to PNG when it
+// cannot hold the image, such as WebP for strips taller than 16383 pixels;
+// a format the format parameter asked for yields an error wrapping
+// cbz.ErrTooLargeForFormat instead.
+func outputFormatFor(r *http.Request, format string, size image.Point) (string, error) {
+	if cbz.FitsFormat(format, size) {
+		return format, nil
+	}
+	if r.URL.Query().Get("format") == "" {
+		return "png", nil
+	}
+	return "", fmt.Errorf("%w: %dx%d cannot be encoded as %s", cbz.ErrTooLargeForFormat, size.X, size.Y, format)
+}
+
+// pngCompressionLevel maps a zlib-style level from 0 (none) to 9 (best) onto
+// the four levels image/png supports.
+func pngCompressionLevel(level int) png.CompressionLevel {
+	switch {
+	case level == 0:
+		return png.NoCompression
+	case level <= 3:
+		return png.BestSpeed
+	case level <= 6:
+		return png.DefaultCompression
+	default:
+		return png.BestCompression
+	}
+}
+
+// parseHexColor parses a color in "rrggbb" or "rrggbbaa" form, with an
+// optional leading '#'.
+func parseHexColor(s string) (color.Color, error) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) != 6 && len(s) != 8 {
+		return nil, fmt.Errorf("color %q must be in rrggbb or rrggbbaa form", s)
+	}
+
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("color %q is not valid hex", s)
+	}
+
+	if len(s) == 6 {
+		return color.NRGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}, nil
 	}
-	return encoder.Encode(w, img)
+	return color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
 }
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// testEntry is a file stored in an archive written by writeTestCBZ.
+type testEntry struct {
+	name string
+	data []byte
+}
+
+// testPage returns a w by h image filled with a gradient.
+func testPage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x * 255 / w), uint8(y * 255 / h), 128, 255})
+		}
+	}
+	return img
+}
+
+// pngPage returns testPage(w, h) encoded as a PNG.
+func pngPage(t testing.TB, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testPage(w, h)); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// jpegPage returns testPage(w, h) encoded as a JPEG.
+func jpegPage(t testing.TB, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testPage(w, h), &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// writeTestCBZ writes entries as a zip archive at dir/name.
+func writeTestCBZ(t testing.TB, dir, name string, entries ...testEntry) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		w, err := zw.Create(entry.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(entry.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestServer returns a Server for a temporary directory holding ch1.cbz,
+// two 100x80 PNG pages, along with the directory.
+func newTestServer(t testing.TB, opts ...Option) (*Server, string) {
+	t.Helper()
+	dir := t.TempDir()
+	writeTestCBZ(t, dir, "ch1.cbz",
+		testEntry{"001.png", pngPage(t, 100, 80)},
+		testEntry{"002.png", pngPage(t, 100, 80)},
+	)
+	return NewServer(append([]Option{WithDirectory(dir)}, opts...)...), dir
+}
+
+// serve sends a request to s and returns the recorded response.
+func serve(s http.Handler, method, target string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+// multipartFile returns a multipart/form-data body holding data as the file
+// field, along with its Content-Type.
+func multipartFile(t testing.TB, filename string, data []byte) (*bytes.Buffer, string) {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := part.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &body, mw.FormDataContentType()
+}
+
+// chromeAccept is the Accept header Chrome sends for image requests.
+const chromeAccept = "image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8"
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", "png"},
+		{"*/*", "png"},
+		{chromeAccept, "webp"},
+		{"image/jpeg, image/png;q=0.5", "jpeg"},
+		{"image/png;q=0.5, image/webp", "webp"},
+		{"application/pdf", "png"},
+		{"application/zip, image/jpeg;q=0.1", "jpeg"},
+		{"multipart/mixed", "png"},
+	}
+
+	for _, tt := range tests {
+		if got := negotiateFormat(tt.accept); got != tt.want {
+			t.Errorf("negotiateFormat(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestWebtoonTallStripFormat(t *testing.T) {
+	s, dir := newTestServer(t)
+	writeTestCBZ(t, dir, "tall.cbz",
+		testEntry{"001.png", pngPage(t, 200, 9000)},
+		testEntry{"002.png", pngPage(t, 200, 9000)},
+	)
+	accept := http.Header{"Accept": {chromeAccept}}
+
+	rec := serve(s, http.MethodGet, "/webtoon?file=tall.cbz", accept)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("negotiated status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "image/png" {
+		t.Errorf("negotiated Content-Type = %q, want image/png", got)
+	}
+
+	rec = serve(s, http.MethodGet, "/webtoon?file=tall.cbz&format=webp", accept)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("format=webp status = %d, want 422", rec.Code)
+	}
+
+	rec = serve(s, http.MethodGet, "/webtoon?file=ch1.cbz", accept)
+	if got := rec.Header().Get("Content-Type"); got != "image/webp" {
+		t.Errorf("short strip Content-Type = %q, want image/webp", got)
+	}
+}
+
+func TestContentLengthMatchesBody(t *testing.T) {
+	s, _ := newTestServer(t)
+	for _, target := range []string{
+		"/webtoon?file=ch1.cbz",
+		"/webtoon?file=ch1.cbz&format=jpeg",
+		"/page?file=ch1.cbz&page=1",
+		"/raw?file=ch1.cbz&page=0",
+		"/thumbnail?file=ch1.cbz&page=0",
+	} {
+		rec := serve(s, http.MethodGet, target, nil)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want 200: %s", target, rec.Code, rec.Body)
+			continue
+		}
+		got := rec.Header().Get("Content-Length")
+		if got == "" {
+			t.Errorf("%s: no Content-Length header", target)
+			continue
+		}
+		if want := strconv.Itoa(rec.Body.Len()); got != want {
+			t.Errorf("%s: Content-Length = %s, body is %s bytes", target, got, want)
+		}
+	}
+}
+
+func TestWebtoonImageSizeHeaders(t *testing.T) {
+	s, _ := newTestServer(t, WithCache(64<<20))
+	// The second request is served from the cache.
+	for i := 0; i < 2; i++ {
+		rec := serve(s, http.MethodGet, "/webtoon?file=ch1.cbz", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+		}
+		if got := rec.Header().Get("X-Image-Width"); got != "100" {
+			t.Errorf("X-Image-Width = %q, want 100", got)
+		}
+		if got := rec.Header().Get("X-Image-Height"); got != "160" {
+			t.Errorf("X-Image-Height = %q, want 160", got)
+		}
+
+		config, err := png.DecodeConfig(rec.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.Width != 100 || config.Height != 160 {
+			t.Errorf("strip is %dx%d, want 100x160", config.Width, config.Height)
+		}
+	}
+}
+
+func TestWebtoonTimeout(t *testing.T) {
+	s, _ := newTestServer(t, WithRequestTimeout(time.Nanosecond))
+	rec := serve(s, http.MethodGet, "/webtoon?file=ch1.cbz", nil)
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want 504: %s", rec.Code, rec.Body)
+	}
+
+	s, _ = newTestServer(t, WithRequestTimeout(time.Minute))
+	if rec := serve(s, http.MethodGet, "/webtoon?file=ch1.cbz", nil); rec.Code != http.StatusOK {
+		t.Fatalf("status with a long timeout = %d, want 200: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestBlankThresholdParameter(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    float64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"blank-threshold=200", 200, false},
+		{"blank-threshold=0.5", 0.5, false},
+		{"blank-threshold=0", 0, true},
+		{"blank-threshold=0.0", 0, true},
+		{"blank-threshold=256", 0, true},
+	}
+
+	for _, tt := range tests {
+		opts, err := parseStripOptions(httptest.NewRequest(http.MethodGet, "/webtoon?skip-blank=true&"+tt.query, nil))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%q: parsed, want error", tt.query)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.query, err)
+		} else if opts.BlankThreshold != tt.want {
+			t.Errorf("%q: BlankThreshold = %g, want %g", tt.query, opts.BlankThreshold, tt.want)
+		}
+	}
+}
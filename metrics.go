@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cbz_requests_total",
+		Help: "Requests served, by status class.",
+	}, []string{"status"})
+
+	processingDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "cbz_processing_duration_seconds",
+		Help:    "Time spent serving instrumented requests.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+	})
+
+	stripPages = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "cbz_strip_pages_total",
+		Help:    "Number of pages composed into each strip.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 10),
+	})
+
+	cacheHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "cbz_cache_hits_total",
+		Help: "Strip requests served from the cache.",
+	})
+
+	cacheMisses = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "cbz_cache_misses_total",
+		Help: "Strip requests that had to be composed.",
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(requestsTotal, processingDuration, stripPages, cacheHits, cacheMisses)
+}
+
+// metricsHandler serves the collected metrics in the Prometheus text format.
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+// instrument records the status class and duration of every request to h.
+func instrument(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		h(rec, r)
+
+		processingDuration.Observe(time.Since(start).Seconds())
+		requestsTotal.WithLabelValues(fmt.Sprintf("%dxx", rec.status/100)).Inc()
+	}
+}
+
+// statusRecorder captures the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, so
+// handlers behind instrument can still flush.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,73 @@
+// Package opds builds minimal OPDS 1.2 catalog documents, the Atom-based
+// feeds e-readers such as KOReader and Panels use to browse libraries.
+package opds
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+)
+
+// Namespaces and media types used by OPDS catalogs.
+const (
+	AtomNamespace = "http://www.w3.org/2005/Atom"
+
+	AcquisitionFeedType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	EntryType           = "application/atom+xml;type=entry;profile=opds-catalog"
+)
+
+// Link relations defined by the OPDS specification.
+const (
+	RelAcquisition = "http://opds-spec.org/acquisition"
+	RelImage       = "http://opds-spec.org/image"
+	RelThumbnail   = "http://opds-spec.org/image/thumbnail"
+)
+
+// Feed is an Atom feed listing catalog entries.
+type Feed struct {
+	XMLName xml.Name  `xml:"feed"`
+	Xmlns   string    `xml:"xmlns,attr"`
+	ID      string    `xml:"id"`
+	Title   string    `xml:"title"`
+	Updated time.Time `xml:"updated"`
+	Links   []Link    `xml:"link"`
+	Entries []Entry   `xml:"entry"`
+}
+
+// Entry is a single publication in a catalog. Xmlns only needs to be set
+// when the entry is served as a standalone document.
+type Entry struct {
+	XMLName xml.Name  `xml:"entry"`
+	Xmlns   string    `xml:"xmlns,attr,omitempty"`
+	ID      string    `xml:"id"`
+	Title   string    `xml:"title"`
+	Updated time.Time `xml:"updated"`
+	Summary string    `xml:"summary,omitempty"`
+	Links   []Link    `xml:"link"`
+}
+
+// Link points from a feed or entry to a related resource.
+type Link struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+// NewFeed returns an empty feed with the Atom namespace set.
+func NewFeed(id, title string, updated time.Time) *Feed {
+	return &Feed{Xmlns: AtomNamespace, ID: id, Title: title, Updated: updated}
+}
+
+// Encode writes v, a Feed or Entry, to w as an XML document.
+func Encode(w io.Writer, v any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	return enc.Close()
+}
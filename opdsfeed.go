@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/alexander-bruun/go-cbz-to-png/cbz"
+	"github.com/alexander-bruun/go-cbz-to-png/opds"
+)
+
+// handleOPDS serves an OPDS acquisition feed of the available archives.
+func (s *Server) handleOPDS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	files, err := cbz.ListArchives(s.dir, s.listDepth)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing archives", "dir", s.dir, "error", err)
+		http.Error(w, "Error listing files", http.StatusInternalServerError)
+		return
+	}
+
+	updated := time.Now()
+	if len(files) > 0 {
+		updated = time.Time{}
+	}
+	for _, file := range files {
+		if file.Mtime.After(updated) {
+			updated = file.Mtime
+		}
+	}
+
+	feed := opds.NewFeed("urn:go-cbz-to-png:catalog", "Comics", updated)
+	feed.Links = []opds.Link{
+		{Rel: "self", Href: "/opds", Type: opds.AcquisitionFeedType},
+		{Rel: "start", Href: "/opds", Type: opds.AcquisitionFeedType},
+	}
+	for _, file := range files {
+		feed.Entries = append(feed.Entries, opdsEntry(file.Name, file.Mtime))
+	}
+
+	w.Header().Set("Content-Type", opds.AcquisitionFeedType)
+	if err := opds.Encode(w, feed); err != nil {
+		slog.ErrorContext(r.Context(), "Error encoding OPDS feed", "error", err)
+	}
+}
+
+// handleOPDSEntry serves the OPDS entry of a single archive, filled in from
+// its ComicInfo.xml when it has one.
+func (s *Server) handleOPDSEntry(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filename, filePath, ok := s.resolveArchivePath(w, r)
+	if !ok {
+		return
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading file info", "file", filename, "error", err)
+		http.Error(w, "Error reading file", http.StatusInternalServerError)
+		return
+	}
+
+	entry := opdsEntry(filename, info.ModTime())
+	entry.Xmlns = opds.AtomNamespace
+
+	comicInfo, err := cbz.ReadComicInfo(filePath)
+	if err != nil && !errors.Is(err, cbz.ErrNoComicInfo) {
+		slog.ErrorContext(r.Context(), "Error reading ComicInfo.xml", "file", filePath, "error", err)
+	}
+	if comicInfo != nil {
+		if comicInfo.Title != "" {
+			entry.Title = comicInfo.Title
+		}
+		entry.Summary = comicInfo.Summary
+	}
+
+	w.Header().Set("Content-Type", opds.EntryType)
+	if err := opds.Encode(w, entry); err != nil {
+		slog.ErrorContext(r.Context(), "Error encoding OPDS entry", "error", err)
+	}
+}
+
+// opdsEntry describes the archive called name, linking to its strip,
+// thumbnail and full entry.
+func opdsEntry(name string, updated time.Time) opds.Entry {
+	query := "?file=" + url.QueryEscape(name)
+	return opds.Entry{
+		ID:      "urn:go-cbz-to-png:" + name,
+		Title:   strings.TrimSuffix(path.Base(name), path.Ext(name)),
+		Updated: updated,
+		Links: []opds.Link{
+			{Rel: opds.RelAcquisition, Href: "/webtoon" + query, Type: "image/png"},
+			{Rel: opds.RelImage, Href: "/page" + query + "&page=0", Type: "image/png"},
+			{Rel: opds.RelThumbnail, Href: "/thumbnail" + query + "&page=0", Type: "image/jpeg"},
+			{Rel: "alternate", Href: "/opds/entry" + query, Type: opds.EntryType},
+		},
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexander-bruun/go-cbz-to-png/cbz"
+)
+
+// handleOptimize serves a copy of an archive with every page re-encoded as
+// JPEG or WebP, which typically makes high-quality scans much smaller.
+func (s *Server) handleOptimize(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filename, filePath, ok := s.resolveArchivePath(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	opts := cbz.OptimizeOptions{Format: "jpeg", Quality: defaultQuality}
+	switch format := strings.ToLower(query.Get("format")); format {
+	case "", "jpeg", "jpg":
+	case "webp":
+		opts.Format = format
+	default:
+		http.Error(w, fmt.Sprintf("Unsupported format: %q", format), http.StatusBadRequest)
+		return
+	}
+	if v := query.Get("quality"); v != "" {
+		q, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid quality parameter: %q", v), http.StatusBadRequest)
+			return
+		}
+		opts.Quality = min(max(q, 1), 100)
+	}
+	opts.MaxInputBytes = s.maxInputBytes
+
+	// Re-encoding every page costs as much as building a strip, so it takes
+	// a job slot for as long as the archive is streamed.
+	release, ok := s.acquireJobSlot(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	optimized, err := cbz.OptimizeCBZ(filePath, opts)
+	if errors.Is(err, cbz.ErrFileTooLarge) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if errors.Is(err, cbz.ErrArchiveEncrypted) {
+		http.Error(w, "Password-protected archives are not supported", http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error optimizing archive", "file", filename, "error", err)
+		http.Error(w, fmt.Sprintf("Error processing file: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if c, ok := optimized.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	base := filepath.Base(filename)
+	w.Header().Set("Content-Type", contentTypes["zip"])
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.cbz\"", strings.TrimSuffix(base, filepath.Ext(base))))
+
+	written, err := io.Copy(w, optimized)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error streaming optimized archive", "file", filename, "error", err)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Served optimized archive",
+		"file", filename,
+		"format", opts.Format,
+		"bytes", written,
+		"duration_ms", time.Since(start).Milliseconds(),
+	)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestOptimizeLimits(t *testing.T) {
+	s, _ := newTestServer(t)
+	rec := serve(s, http.MethodGet, "/optimize?file=ch1.cbz", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	s, _ = newTestServer(t, WithLimits(100, 0))
+	if rec := serve(s, http.MethodGet, "/optimize?file=ch1.cbz", nil); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized archive status = %d, want 413", rec.Code)
+	}
+
+	s, _ = newTestServer(t, WithMaxConcurrentJobs(1, true))
+	s.jobSlots <- struct{}{}
+	if rec := serve(s, http.MethodGet, "/optimize?file=ch1.cbz", nil); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("busy server status = %d, want 503", rec.Code)
+	}
+}
+
+func TestOptimizeKeepsCollidingNames(t *testing.T) {
+	s, dir := newTestServer(t)
+	writeTestCBZ(t, dir, "collide.cbz",
+		testEntry{"001.jpg", jpegPage(t, 40, 30)},
+		testEntry{"001.png", pngPage(t, 40, 30)},
+		testEntry{"002.png", pngPage(t, 40, 30)},
+	)
+
+	rec := serve(s, http.MethodGet, "/optimize?file=collide.cbz", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if want := []string{"001.jpg", "001.png", "002.jpg"}; !slices.Equal(names, want) {
+		t.Errorf("entries = %q, want %q", names, want)
+	}
+}
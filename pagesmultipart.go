@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexander-bruun/go-cbz-to-png/cbz"
+)
+
+// servePagesMultipart streams the pages of the archives at filePaths as a
+// multipart/mixed response with one part per page, each carrying an
+// X-Page-Index header, so clients can render the first page while the rest
+// are still being sent. Parts are PNG unless page-format selects jpeg or
+// webp. Each page is processed with the same filters the strip would get.
+func (s *Server) servePagesMultipart(w http.ResponseWriter, r *http.Request, filename string, filePaths []string, opts cbz.StripOptions, encodeOpts cbz.EncodeOptions) {
+	start := time.Now()
+
+	pageFormat := strings.ToLower(r.URL.Query().Get("page-format"))
+	switch pageFormat {
+	case "":
+		pageFormat = "png"
+	case "jpg":
+		pageFormat = "jpeg"
+	}
+	if pageFormat != "png" && pageFormat != "jpeg" && pageFormat != "webp" {
+		http.Error(w, fmt.Sprintf("unsupported page-format: %q", pageFormat), http.StatusBadRequest)
+		return
+	}
+
+	pages, result, err := cbz.OpenStrips(r.Context(), filePaths, opts)
+	if err != nil {
+		writeStripError(w, r, filename, err)
+		return
+	}
+
+	layoutOrder(pages, &result, opts)
+	pageOpts := pageOptions(opts)
+	rc := http.NewResponseController(w)
+	mw := multipart.NewWriter(w)
+
+	w.Header().Set("X-Page-Count", strconv.Itoa(result.PageCount))
+	w.Header().Set("X-Skipped-Pages", strconv.Itoa(result.SkippedPages))
+	w.Header().Set("X-Skipped-Blank-Pages", strconv.Itoa(result.SkippedBlankPages))
+	w.Header().Set("X-Page-Widths", joinInts(result.PageWidths))
+	w.Header().Set("Content-Type", contentTypes["multipart"]+"; boundary="+mw.Boundary())
+
+	var buf bytes.Buffer
+	for i, page := range pages {
+		if err := writePagePart(mw, &buf, page, i, pageFormat, pageOpts, encodeOpts); err != nil {
+			// The status has already been sent, so the missing closing
+			// boundary is all the client will see.
+			slog.ErrorContext(r.Context(), "Error streaming page parts", "file", filename, "page", i, "error", err)
+			return
+		}
+		// Writers that cannot flush send the parts when the response
+		// ends instead.
+		rc.Flush()
+	}
+	if err := mw.Close(); err != nil {
+		slog.ErrorContext(r.Context(), "Error streaming page parts", "file", filename, "error", err)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Served page parts",
+		"file", filename,
+		"pages", len(pages),
+		"duration_ms", time.Since(start).Milliseconds(),
+	)
+}
+
+// writePagePart encodes page as format into buf and writes it to mw as the
+// part for the page at index.
+func writePagePart(mw *multipart.Writer, buf *bytes.Buffer, page image.Image, index int, format string, opts cbz.StripOptions, encodeOpts cbz.EncodeOptions) error {
+	buf.Reset()
+	img := cbz.CompositeStrip([]image.Image{page}, opts)
+	err := cbz.EncodeWithOptions(buf, img, format, encodeOpts)
+	cbz.ReleaseStrip(img)
+	if err != nil {
+		return err
+	}
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", contentTypes[format])
+	header.Set("Content-Length", strconv.Itoa(buf.Len()))
+	header.Set("X-Page-Index", strconv.Itoa(index))
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	_, err = buf.WriteTo(part)
+	return err
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestPagesMultipartFlushesEachPart(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	rec := serve(s, http.MethodGet, "/webtoon?file=ch1.cbz&format=multipart", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if !rec.Flushed {
+		t.Error("parts were not flushed through the instrumented writer")
+	}
+
+	_, params, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr := multipart.NewReader(rec.Body, params["boundary"])
+	parts := 0
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := part.Header.Get("Content-Type"); got != "image/png" {
+			t.Errorf("part Content-Type = %q, want image/png", got)
+		}
+		parts++
+	}
+	if parts != 2 {
+		t.Errorf("got %d parts, want 2", parts)
+	}
+}
+
+func TestPagesRightToLeft(t *testing.T) {
+	s, dir := newTestServer(t)
+	writeTestCBZ(t, dir, "sizes.cbz",
+		testEntry{"001.png", pngPage(t, 100, 80)},
+		testEntry{"002.png", pngPage(t, 100, 60)},
+	)
+
+	for _, format := range []string{"zip", "multipart"} {
+		rec := serve(s, http.MethodGet, "/webtoon?file=sizes.cbz&rtl=true&format="+format, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want 200: %s", format, rec.Code, rec.Body)
+		}
+
+		var heights []int
+		if format == "zip" {
+			zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range zr.File {
+				rc, err := f.Open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				heights = append(heights, decodedHeight(t, rc))
+				rc.Close()
+			}
+		} else {
+			_, params, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			mr := multipart.NewReader(rec.Body, params["boundary"])
+			for {
+				part, err := mr.NextPart()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				heights = append(heights, decodedHeight(t, part))
+			}
+		}
+
+		if want := []int{60, 80}; !slices.Equal(heights, want) {
+			t.Errorf("%s: page heights = %v, want %v", format, heights, want)
+		}
+	}
+}
+
+// decodedHeight returns the height of the image r holds.
+func decodedHeight(t *testing.T, r io.Reader) int {
+	t.Helper()
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg.Height
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"image"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/alexander-bruun/go-cbz-to-png/cbz"
+)
+
+// servePagesZip streams the pages of the archives at filePaths as a zip of
+// PNGs named page_000.png, page_001.png and so on, instead of compositing
+// them into a strip. Each page is processed with the same filters the strip
+// would get.
+func (s *Server) servePagesZip(w http.ResponseWriter, r *http.Request, filename string, filePaths []string, opts cbz.StripOptions, encodeOpts cbz.EncodeOptions) {
+	start := time.Now()
+
+	pages, result, err := cbz.OpenStrips(r.Context(), filePaths, opts)
+	if err != nil {
+		writeStripError(w, r, filename, err)
+		return
+	}
+
+	layoutOrder(pages, &result, opts)
+	pageOpts := pageOptions(opts)
+
+	w.Header().Set("X-Page-Count", strconv.Itoa(result.PageCount))
+	w.Header().Set("X-Skipped-Pages", strconv.Itoa(result.SkippedPages))
+	w.Header().Set("X-Skipped-Blank-Pages", strconv.Itoa(result.SkippedBlankPages))
+	w.Header().Set("X-Page-Widths", joinInts(result.PageWidths))
+	w.Header().Set("Content-Type", contentTypes["zip"])
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.zip\"", filepath.Base(filename)))
+
+	zw := zip.NewWriter(w)
+	for i, page := range pages {
+		// PNG data is already deflated; compressing it again would
+		// only cost time.
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     fmt.Sprintf("page_%03d.png", i),
+			Method:   zip.Store,
+			Modified: start,
+		})
+		if err == nil {
+			img := cbz.CompositeStrip([]image.Image{page}, pageOpts)
+			err = cbz.EncodeWithOptions(fw, img, "png", encodeOpts)
+			cbz.ReleaseStrip(img)
+		}
+		if err != nil {
+			// The status has already been sent, so the truncated zip
+			// is all the client will see.
+			slog.ErrorContext(r.Context(), "Error streaming page zip", "file", filename, "page", i, "error", err)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		slog.ErrorContext(r.Context(), "Error streaming page zip", "file", filename, "error", err)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Served page zip",
+		"file", filename,
+		"pages", len(pages),
+		"duration_ms", time.Since(start).Milliseconds(),
+	)
+}
+
+// isPagesFormat reports whether format sends each page separately instead of
+// a composited strip.
+func isPagesFormat(format string) bool {
+	return format == "zip" || format == "multipart"
+}
+
+// layoutOrder reverses pages and result.PageWidths in place when
+// opts.RightToLeft is set, so pages are sent in the order a strip would lay
+// them out.
+func layoutOrder(pages []image.Image, result *cbz.StripResult, opts cbz.StripOptions) {
+	if opts.RightToLeft {
+		slices.Reverse(pages)
+		slices.Reverse(result.PageWidths)
+	}
+}
+
+// pageOptions returns opts for processing pages one at a time with
+// cbz.CompositeStrip. Each page is its own image, so layout options no
+// longer apply; layoutOrder takes care of RightToLeft.
+func pageOptions(opts cbz.StripOptions) cbz.StripOptions {
+	opts.RightToLeft = false
+	opts.Horizontal = false
+	opts.GridColumns = 0
+	return opts
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/alexander-bruun/go-cbz-to-png/cbz"
+)
+
+// progressEvent is the data of each Server-Sent Event from /progress.
+type progressEvent struct {
+	Page    int `json:"page"`
+	Total   int `json:"total"`
+	Percent int `json:"percent"`
+}
+
+// handleProgress builds a strip while reporting progress as Server-Sent
+// Events: one message per decoded page, then a "done" event naming the job
+// whose result holds the encoded strip, or an "error" event. Browser UIs can
+// show a progress bar without polling /jobs.
+func (s *Server) handleProgress(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	filename, filePath, ok := s.resolveArchivePath(w, r)
+	if !ok {
+		return
+	}
+
+	opts, err := parseStripOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts.MaxInputBytes = s.maxInputBytes
+	opts.MaxOutputPixels = s.maxOutputPixels
+
+	format, encodeOpts, err := parseOutputFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if isPagesFormat(format) {
+		http.Error(w, fmt.Sprintf("Format %s is not supported for progress", format), http.StatusBadRequest)
+		return
+	}
+
+	release, ok := s.acquireJobSlot(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	send := func(event string, data any) {
+		if event != "" {
+			fmt.Fprintf(w, "event: %s\n", event)
+		}
+		payload, _ := json.Marshal(data)
+		fmt.Fprintf(w, "data: %s\n\n", payload)
+		flusher.Flush()
+	}
+
+	// The callback runs while this goroutine waits in webtoonStrip, so the
+	// writes never overlap.
+	decoded := 0
+	opts.OnPageDecoded = func(_, total int, _ image.Image) {
+		decoded++
+		send("", progressEvent{Page: decoded, Total: total, Percent: decoded * 100 / total})
+	}
+
+	fail := func(err error) {
+		slog.ErrorContext(r.Context(), "Error creating webtoon strip", "file", filename, "error", err)
+		send("error", map[string]string{"error": err.Error()})
+	}
+
+	img, _, err := s.webtoonStrip(r.Context(), filePath, opts)
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	defer s.releaseStrip(img)
+
+	if format, err = outputFormatFor(r, format, img.Bounds().Size()); err != nil {
+		fail(err)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := cbz.EncodeWithOptions(&buf, img, format, encodeOpts); err != nil {
+		fail(err)
+		return
+	}
+
+	j := &job{
+		status:      jobPending,
+		contentType: contentTypes[format],
+		filename:    fmt.Sprintf("%s.%s", filepath.Base(filename), format),
+	}
+	id, ok := s.jobs.add(j)
+	if !ok {
+		fail(errors.New("too many pending jobs"))
+		return
+	}
+	if err := s.jobs.finish(j, buf.Bytes(), nil); err != nil {
+		fail(err)
+		return
+	}
+	send("done", map[string]string{"jobId": id})
+
+	slog.InfoContext(r.Context(), "Finished progress job", "job", id, "file", filename, "duration_ms", time.Since(start).Milliseconds())
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/alexander-bruun/go-cbz-to-png/cbz"
+)
+
+// handleQuality decodes every page of an archive and returns its resolution
+// and sharpness as a JSON array, flagging low-resolution or blurry scans for
+// quality control.
+func (s *Server) handleQuality(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filename, filePath, ok := s.resolveArchivePath(w, r)
+	if !ok {
+		return
+	}
+
+	release, ok := s.acquireJobSlot(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	ctx := r.Context()
+	if s.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.requestTimeout)
+		defer cancel()
+	}
+
+	scores, err := cbz.ScorePages(ctx, filePath)
+	switch {
+	case errors.Is(err, cbz.ErrArchiveEncrypted):
+		http.Error(w, "Password-protected archives are not supported", http.StatusUnprocessableEntity)
+		return
+	case errors.Is(err, context.DeadlineExceeded):
+		slog.WarnContext(r.Context(), "Timed out scoring page quality", "file", filename)
+		http.Error(w, "Timed out processing file", http.StatusGatewayTimeout)
+		return
+	case err != nil:
+		slog.ErrorContext(r.Context(), "Error scoring page quality", "file", filename, "error", err)
+		http.Error(w, fmt.Sprintf("Error processing file: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(scores); err != nil {
+		slog.ErrorContext(r.Context(), "Error encoding page quality", "error", err)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Scored page quality",
+		"file", filename,
+		"pages", len(scores),
+		"duration_ms", time.Since(start).Milliseconds(),
+	)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimiterTTL is how long a client's limiter is kept after its last
+// request.
+const rateLimiterTTL = 10 * time.Minute
+
+// clientLimiter is the token bucket for a single client IP.
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	mu       sync.Mutex
+	lastSeen time.Time
+}
+
+// ipRateLimiter hands out a token bucket per client IP.
+type ipRateLimiter struct {
+	rps     rate.Limit
+	burst   int
+	clients sync.Map // map[string]*clientLimiter
+}
+
+func newIPRateLimiter(rps float64, burst int) *ipRateLimiter {
+	l := &ipRateLimiter{rps: rate.Limit(rps), burst: burst}
+	go l.cleanup()
+	return l
+}
+
+// reserve takes a token for ip, returning how long the client must wait
+// before a token is available if none is available now.
+func (l *ipRateLimiter) reserve(ip string) (time.Duration, bool) {
+	value, _ := l.clients.LoadOrStore(ip, &clientLimiter{limiter: rate.NewLimiter(l.rps, l.burst)})
+	client := value.(*clientLimiter)
+
+	client.mu.Lock()
+	client.lastSeen = time.Now()
+	client.mu.Unlock()
+
+	reservation := client.limiter.Reserve()
+	if !reservation.OK() {
+		return 0, false
+	}
+	if delay := reservation.Delay(); delay > 0 {
+		reservation.Cancel()
+		return delay, false
+	}
+	return 0, true
+}
+
+// cleanup periodically forgets clients that have been idle for longer than
+// rateLimiterTTL.
+func (l *ipRateLimiter) cleanup() {
+	for range time.Tick(rateLimiterTTL) {
+		l.clients.Range(func(key, value any) bool {
+			client := value.(*clientLimiter)
+			client.mu.Lock()
+			idle := time.Since(client.lastSeen)
+			client.mu.Unlock()
+
+			if idle > rateLimiterTTL {
+				l.clients.Delete(key)
+			}
+			return true
+		})
+	}
+}
+
+// withRateLimit rejects requests with 429 Too Many Requests once a client IP
+// exceeds its token bucket.
+func withRateLimit(l *ipRateLimiter, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+
+		if delay, ok := l.reserve(ip); !ok {
+			retryAfter := max(int(math.Ceil(delay.Seconds())), 1)
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
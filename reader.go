@@ -0,0 +1,37 @@
+package main
+
+import (
+	"embed"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// webFiles holds the static pages served by the server.
+//
+//go:embed web
+var webFiles embed.FS
+
+// handleReader serves a self-contained browser reader that loads the archive
+// named by the file query parameter.
+func (s *Server) handleReader(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, _, ok := s.resolveArchivePath(w, r); !ok {
+		return
+	}
+
+	page, err := webFiles.ReadFile("web/reader.html")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading reader page", "error", err)
+		http.Error(w, "Error loading reader", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(page)))
+	w.Write(page)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/alexander-bruun/go-cbz-to-png/cbz"
+)
+
+// WithRemote allows the file parameter of /webtoon to be an http:// or
+// https:// URL, fetched with the given timeout. This lets clients make the
+// server issue requests to arbitrary hosts, so it is off by default.
+func WithRemote(timeout time.Duration) Option {
+	return func(s *Server) {
+		s.remoteClient = &http.Client{Timeout: timeout}
+	}
+}
+
+// isRemoteFile reports whether the file parameter names a URL rather than a
+// path in the archive directory.
+func isRemoteFile(filename string) bool {
+	return strings.HasPrefix(filename, "http://") || strings.HasPrefix(filename, "https://")
+}
+
+// fetchRemoteArchive downloads the archive at rawURL into a temporary file
+// and returns its display name and path, along with a function that removes
+// the file. Remote files are subject to the same limit as uploads. On
+// failure it writes an error response and returns false.
+func (s *Server) fetchRemoteArchive(w http.ResponseWriter, r *http.Request, rawURL string) (string, string, func(), bool) {
+	if s.remoteClient == nil {
+		http.Error(w, "Remote files are not allowed", http.StatusForbidden)
+		return "", "", nil, false
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		http.Error(w, "Invalid file URL", http.StatusBadRequest)
+		return "", "", nil, false
+	}
+
+	ext := path.Ext(u.Path)
+	if !cbz.IsArchiveFile(u.Path) {
+		http.Error(w, "Invalid file extension. Only .cbz, .cbr, .cbt and .epub files are allowed", http.StatusBadRequest)
+		return "", "", nil, false
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, u.String(), nil)
+	if err != nil {
+		http.Error(w, "Invalid file URL", http.StatusBadRequest)
+		return "", "", nil, false
+	}
+
+	resp, err := s.remoteClient.Do(req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching remote file", "url", rawURL, "error", err)
+		http.Error(w, "Error fetching remote file", http.StatusBadGateway)
+		return "", "", nil, false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		slog.ErrorContext(r.Context(), "Error fetching remote file", "url", rawURL, "status", resp.StatusCode)
+		http.Error(w, fmt.Sprintf("Error fetching remote file: %s", resp.Status), http.StatusBadGateway)
+		return "", "", nil, false
+	}
+
+	limit := s.transferLimit()
+	if resp.ContentLength > limit {
+		writeRemoteTooLarge(w, limit)
+		return "", "", nil, false
+	}
+
+	// The archive is dispatched on its extension, so the temp file keeps it.
+	file, err := os.CreateTemp("", "cbz-remote-*"+strings.ToLower(ext))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error creating temp file", "error", err)
+		http.Error(w, "Error fetching remote file", http.StatusInternalServerError)
+		return "", "", nil, false
+	}
+	cleanup := func() { os.Remove(file.Name()) }
+
+	n, err := io.Copy(file, io.LimitReader(resp.Body, limit+1))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cleanup()
+		slog.ErrorContext(r.Context(), "Error fetching remote file", "url", rawURL, "error", err)
+		http.Error(w, "Error fetching remote file", http.StatusBadGateway)
+		return "", "", nil, false
+	}
+	if n > limit {
+		cleanup()
+		writeRemoteTooLarge(w, limit)
+		return "", "", nil, false
+	}
+
+	return path.Base(u.Path), file.Name(), cleanup, true
+}
+
+// writeRemoteTooLarge rejects a remote file larger than limit bytes.
+func writeRemoteTooLarge(w http.ResponseWriter, limit int64) {
+	http.Error(w, fmt.Sprintf("%v: remote file exceeds the %d byte limit", cbz.ErrFileTooLarge, limit), http.StatusRequestEntityTooLarge)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRemoteArchive(t *testing.T) {
+	_, dir := newTestServer(t)
+	data, err := os.ReadFile(filepath.Join(dir, "ch1.cbz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fetched int
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched++
+		w.Write(data)
+	}))
+	defer origin.Close()
+	target := "/webtoon?file=" + origin.URL + "/ch1.cbz"
+
+	s, _ := newTestServer(t, WithRemote(time.Minute))
+	if rec := serve(s, http.MethodGet, target, nil); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	s, _ = newTestServer(t, WithRemote(time.Minute), WithLimits(int64(len(data))-1, 0))
+	if rec := serve(s, http.MethodGet, target, nil); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized remote file status = %d, want 413", rec.Code)
+	}
+
+	fetched = 0
+	s, _ = newTestServer(t, WithRemote(time.Minute))
+	if rec := serve(s, http.MethodGet, target+"&gap=-1", nil); rec.Code != http.StatusBadRequest || fetched != 0 {
+		t.Errorf("invalid options: status %d after %d fetches, want 400 without fetching", rec.Code, fetched)
+	}
+}
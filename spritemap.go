@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"image"
+)
+
+// spriteRect is where one page lies in a sprite sheet.
+type spriteRect struct {
+	Index  int `json:"index"`
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// encodeSpriteMap returns the X-Sprite-Map header value for a strip whose
+// pages lie at rects: base64-encoded JSON of the form
+// {"pages":[{"index":0,"x":0,"y":0,"width":800,"height":1200},...]}.
+func encodeSpriteMap(rects []image.Rectangle) (string, error) {
+	pages := make([]spriteRect, len(rects))
+	for i, rect := range rects {
+		pages[i] = spriteRect{
+			Index:  i,
+			X:      rect.Min.X,
+			Y:      rect.Min.Y,
+			Width:  rect.Dx(),
+			Height: rect.Dy(),
+		}
+	}
+
+	data, err := json.Marshal(map[string][]spriteRect{"pages": pages})
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
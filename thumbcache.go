@@ -0,0 +1,107 @@
+package main
+
+import (
+	"container/list"
+	"image"
+	"sync"
+	"time"
+)
+
+// thumbnailKey identifies a cached thumbnail.
+type thumbnailKey struct {
+	path  string
+	index int
+	width int
+}
+
+type thumbnailCacheEntry struct {
+	key   thumbnailKey
+	img   image.Image
+	mtime time.Time
+	size  int64
+}
+
+// thumbnailCache is an LRU cache of thumbnails bounded by the total number
+// of pixel bytes it holds, like stripCache.
+type thumbnailCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	curBytes int64
+	order    *list.List
+	entries  map[thumbnailKey]*list.Element
+}
+
+func newThumbnailCache(maxBytes int64) *thumbnailCache {
+	return &thumbnailCache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[thumbnailKey]*list.Element),
+	}
+}
+
+// Get returns the cached thumbnail for key if it was built from a file with
+// the given mtime. Stale entries are evicted.
+func (c *thumbnailCache) Get(key thumbnailKey, mtime time.Time) (image.Image, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(*thumbnailCacheEntry)
+	if !entry.mtime.Equal(mtime) {
+		c.remove(elem)
+		return nil, false
+	}
+
+	c.order.MoveToFront(elem)
+	return entry.img, true
+}
+
+// Add stores img under key, evicting the least recently used thumbnails
+// until the cache fits within its byte budget.
+func (c *thumbnailCache) Add(key thumbnailKey, mtime time.Time, img image.Image) {
+	size := imageBytes(img)
+	if size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+
+	for c.curBytes+size > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+
+	entry := &thumbnailCacheEntry{key: key, img: img, mtime: mtime, size: size}
+	c.entries[key] = c.order.PushFront(entry)
+	c.curBytes += size
+}
+
+// RemovePath evicts every thumbnail of the archive at path and returns how
+// many were evicted.
+func (c *thumbnailCache) RemovePath(path string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	evicted := 0
+	for key, elem := range c.entries {
+		if key.path == path {
+			c.remove(elem)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+func (c *thumbnailCache) remove(elem *list.Element) {
+	entry := c.order.Remove(elem).(*thumbnailCacheEntry)
+	delete(c.entries, entry.key)
+	c.curBytes -= entry.size
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"image"
+	"image/jpeg"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestThumbnailCacheBounded(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10)) // 400 bytes
+	c := newThumbnailCache(1000)
+	mtime := time.Now()
+
+	for i := range 5 {
+		c.Add(thumbnailKey{path: "a.cbz", index: i, width: 10}, mtime, img)
+	}
+	if c.curBytes > c.maxBytes {
+		t.Errorf("cache holds %d bytes, over its %d byte budget", c.curBytes, c.maxBytes)
+	}
+	if _, ok := c.Get(thumbnailKey{path: "a.cbz", index: 0, width: 10}, mtime); ok {
+		t.Error("least recently used thumbnail was not evicted")
+	}
+	if _, ok := c.Get(thumbnailKey{path: "a.cbz", index: 4, width: 10}, mtime); !ok {
+		t.Error("most recent thumbnail was evicted")
+	}
+}
+
+func TestThumbnailCacheStale(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	c := newThumbnailCache(1 << 20)
+	key := thumbnailKey{path: "a.cbz", index: 0, width: 10}
+	mtime := time.Now()
+
+	c.Add(key, mtime, img)
+	if _, ok := c.Get(key, mtime.Add(time.Second)); ok {
+		t.Error("thumbnail of a modified archive was served")
+	}
+	if len(c.entries) != 0 {
+		t.Error("stale thumbnail was not evicted")
+	}
+
+	c.Add(key, mtime, img)
+	if n := c.RemovePath("a.cbz"); n != 1 {
+		t.Errorf("RemovePath evicted %d thumbnails, want 1", n)
+	}
+}
+
+func TestThumbnailWidthClamped(t *testing.T) {
+	s, dir := newTestServer(t)
+	writeTestCBZ(t, dir, "wide.cbz", testEntry{"001.png", pngPage(t, 2000, 20)})
+
+	rec := serve(s, http.MethodGet, "/thumbnail?file=wide.cbz&page=0&width=100000", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	config, err := jpeg.DecodeConfig(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != maxThumbnailWidth {
+		t.Errorf("thumbnail width = %d, want %d", config.Width, maxThumbnailWidth)
+	}
+	if len(s.thumbnails.entries) != 1 {
+		t.Errorf("thumbnail cache holds %d entries, want 1", len(s.thumbnails.entries))
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/tls"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeOverTLS(t *testing.T) {
+	s, _ := newTestServer(t)
+	srv := httptest.NewTLSServer(s)
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL + "/webtoon?file=ch1.cbz&format=png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	if resp.TLS == nil {
+		t.Fatal("response was not served over TLS")
+	}
+	img, err := png.Decode(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := img.Bounds().Dy(); got != 160 {
+		t.Errorf("strip height = %d, want 160", got)
+	}
+}
+
+func TestTLSMinVersion(t *testing.T) {
+	s, _ := newTestServer(t)
+	srv := httptest.NewUnstartedServer(s)
+	srv.TLS = &tls.Config{MinVersion: tlsVersions["1.3"]}
+	srv.StartTLS()
+	defer srv.Close()
+
+	client := srv.Client()
+	client.Transport.(*http.Transport).TLSClientConfig.MaxVersion = tls.VersionTLS12
+	if resp, err := client.Get(srv.URL + "/healthz"); err == nil {
+		resp.Body.Close()
+		t.Fatal("TLS 1.2 client connected to a server requiring TLS 1.3")
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alexander-bruun/go-cbz-to-png/cbz"
+)
+
+// defaultMaxTransferBytes is the size limit of uploaded and remote archives
+// when -max-input-bytes is not set, so a client cannot fill the temp
+// directory.
+const defaultMaxTransferBytes = 1 << 30
+
+// maxUploadOverhead is how many bytes of a multipart upload beyond the
+// archive itself are accepted, for part headers, boundaries and other fields.
+const maxUploadOverhead = 1 << 20
+
+// transferLimit returns the size limit of uploaded and remote archives.
+func (s *Server) transferLimit() int64 {
+	if s.maxInputBytes > 0 {
+		return s.maxInputBytes
+	}
+	return defaultMaxTransferBytes
+}
+
+// receiveUploadedArchive streams the archive uploaded as the "file" field of
+// a multipart/form-data request into a temporary file and returns its
+// display name and path, along with a function that removes the file. The
+// upload is subject to -max-input-bytes like archives on disk, or to
+// defaultMaxTransferBytes without it. On failure it writes an error response
+// and returns false.
+func (s *Server) receiveUploadedArchive(w http.ResponseWriter, r *http.Request) (string, string, func(), bool) {
+	limit := s.transferLimit()
+	r.Body = http.MaxBytesReader(w, r.Body, limit+maxUploadOverhead)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid upload: %v", err), http.StatusBadRequest)
+		return "", "", nil, false
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			http.Error(w, "Invalid upload: missing file field", http.StatusBadRequest)
+			return "", "", nil, false
+		}
+		if err != nil {
+			if isMaxBytesError(err) {
+				writeUploadTooLarge(w, limit)
+			} else {
+				http.Error(w, fmt.Sprintf("Invalid upload: %v", err), http.StatusBadRequest)
+			}
+			return "", "", nil, false
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+
+		filename := filepath.Base(part.FileName())
+		if !cbz.IsArchiveFile(filename) {
+			http.Error(w, "Invalid file extension. Only .cbz, .cbr, .cbt and .epub files are allowed", http.StatusBadRequest)
+			return "", "", nil, false
+		}
+
+		// The archive is dispatched on its extension, so the temp file keeps it.
+		file, err := os.CreateTemp("", "cbz-upload-*"+strings.ToLower(filepath.Ext(filename)))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error creating temp file", "error", err)
+			http.Error(w, "Error receiving upload", http.StatusInternalServerError)
+			return "", "", nil, false
+		}
+		cleanup := func() { os.Remove(file.Name()) }
+
+		n, err := io.Copy(file, io.LimitReader(part, limit+1))
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil && !isMaxBytesError(err) {
+			cleanup()
+			slog.ErrorContext(r.Context(), "Error receiving upload", "file", filename, "error", err)
+			http.Error(w, "Error receiving upload", http.StatusBadRequest)
+			return "", "", nil, false
+		}
+		if err != nil || n > limit {
+			cleanup()
+			writeUploadTooLarge(w, limit)
+			return "", "", nil, false
+		}
+
+		return filename, file.Name(), cleanup, true
+	}
+}
+
+// isMaxBytesError reports whether err comes from a body exceeding the limit
+// of http.MaxBytesReader.
+func isMaxBytesError(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+// writeUploadTooLarge rejects an upload larger than limit bytes.
+func writeUploadTooLarge(w http.ResponseWriter, limit int64) {
+	http.Error(w, fmt.Sprintf("%v: upload exceeds the %d byte limit", cbz.ErrFileTooLarge, limit), http.StatusRequestEntityTooLarge)
+}
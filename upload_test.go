@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// postUpload posts body to target on s as a multipart upload.
+func postUpload(s http.Handler, target string, body *bytes.Buffer, contentType string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, target, body)
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestUpload(t *testing.T) {
+	s, dir := newTestServer(t)
+	data, err := os.ReadFile(filepath.Join(dir, "ch1.cbz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, contentType := multipartFile(t, "ch1.cbz", data)
+	rec := postUpload(s, "/webtoon", body, contentType)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("X-Image-Height"); got != "160" {
+		t.Errorf("X-Image-Height = %q, want 160", got)
+	}
+
+	s, _ = newTestServer(t, WithLimits(int64(len(data))-1, 0))
+	body, contentType = multipartFile(t, "ch1.cbz", data)
+	if rec := postUpload(s, "/webtoon", body, contentType); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized upload status = %d, want 413", rec.Code)
+	}
+}
+
+// unreadBody is a request body that records whether it was read.
+type unreadBody struct {
+	read bool
+}
+
+func (b *unreadBody) Read([]byte) (int, error) {
+	b.read = true
+	return 0, io.ErrUnexpectedEOF
+}
+
+func TestUploadRejectedBeforeReading(t *testing.T) {
+	post := func(s http.Handler, target string) (*httptest.ResponseRecorder, bool) {
+		body := &unreadBody{}
+		req := httptest.NewRequest(http.MethodPost, target, body)
+		req.Header.Set("Content-Type", "multipart/form-data; boundary=x")
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec, body.read
+	}
+
+	s, _ := newTestServer(t)
+	if rec, read := post(s, "/webtoon?gap=-1"); rec.Code != http.StatusBadRequest || read {
+		t.Errorf("invalid options: status %d, body read %v; want 400 without reading", rec.Code, read)
+	}
+
+	s, _ = newTestServer(t, WithMaxConcurrentJobs(1, true))
+	s.jobSlots <- struct{}{}
+	if rec, read := post(s, "/webtoon"); rec.Code != http.StatusServiceUnavailable || read {
+		t.Errorf("busy server: status %d, body read %v; want 503 without reading", rec.Code, read)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/alexander-bruun/go-cbz-to-png/cbz"
+)
+
+// validationReport is the JSON response of /validate.
+type validationReport struct {
+	File   string                `json:"file"`
+	Valid  bool                  `json:"valid"`
+	Issues []cbz.ValidationIssue `json:"issues"`
+}
+
+// handleValidate checks an archive for missing pages, duplicate entries and
+// empty entries without decoding any pages.
+func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filename, filePath, ok := s.resolveArchivePath(w, r)
+	if !ok {
+		return
+	}
+
+	issues, err := cbz.ValidateArchive(filePath)
+	switch {
+	case errors.Is(err, cbz.ErrArchiveEncrypted):
+		http.Error(w, "Password-protected archives are not supported", http.StatusUnprocessableEntity)
+		return
+	case errors.Is(err, cbz.ErrArchiveTruncated) || errors.Is(err, cbz.ErrUnsafeEntryName) || errors.Is(err, cbz.ErrEPUBNotImages):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		slog.ErrorContext(r.Context(), "Error validating archive", "file", filename, "error", err)
+		http.Error(w, fmt.Sprintf("Error processing file: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if len(issues) > 0 {
+		slog.InfoContext(r.Context(), "Archive failed validation", "file", filename, "issues", len(issues))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	report := validationReport{File: filename, Valid: len(issues) == 0, Issues: issues}
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		slog.ErrorContext(r.Context(), "Error encoding validation report", "error", err)
+	}
+}
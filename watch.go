@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/alexander-bruun/go-cbz-to-png/cbz"
+)
+
+// watchArchives watches the archive directory, and the subdirectories /list
+// descends into, until ctx is done. Whenever an archive is written, replaced
+// or removed, its cached strips are evicted straight away instead of
+// lingering until the next request notices the new modification time.
+func (s *Server) watchArchives(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	err = filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		if !s.watchesDir(path) {
+			return filepath.SkipDir
+		}
+		return watcher.Add(path)
+	})
+	if err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				s.handleWatchEvent(watcher, event)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Error("Error watching archive directory", "dir", s.dir, "error", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// watchesDir reports whether dir is within the depth /list descends into.
+func (s *Server) watchesDir(dir string) bool {
+	rel, err := filepath.Rel(s.dir, dir)
+	if err != nil {
+		return false
+	}
+	return rel == "." || strings.Count(filepath.ToSlash(rel), "/") < s.listDepth
+}
+
+// handleWatchEvent evicts the cached strips and thumbnails of the archive
+// event concerns and starts watching new subdirectories.
+func (s *Server) handleWatchEvent(watcher *fsnotify.Watcher, event fsnotify.Event) {
+	if event.Has(fsnotify.Create) {
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() && s.watchesDir(event.Name) {
+			if err := watcher.Add(event.Name); err != nil {
+				slog.Error("Error watching directory", "dir", event.Name, "error", err)
+			}
+			return
+		}
+	}
+
+	// Create covers archives replaced by renaming a new file over them.
+	if !cbz.IsArchiveFile(event.Name) || !event.Has(fsnotify.Write|fsnotify.Remove|fsnotify.Rename|fsnotify.Create) {
+		return
+	}
+	path := filepath.Clean(event.Name)
+	if evicted := s.thumbnails.RemovePath(path); evicted > 0 {
+		slog.Info("Invalidated cached thumbnails", "file", event.Name, "op", event.Op.String(), "entries", evicted)
+	}
+	if s.cache == nil {
+		return
+	}
+	if evicted := s.cache.RemovePath(path); evicted > 0 {
+		slog.Info("Invalidated cached strips", "file", event.Name, "op", event.Op.String(), "entries", evicted)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/alexander-bruun/go-cbz-to-png/cbz"
+)
+
+// wsStart is the JSON control message sent before the pages on
+// /ws/webtoon, with the number of pages selected for the strip.
+type wsStart struct {
+	PageCount int `json:"pageCount"`
+}
+
+// wsDone is the JSON control message sent after the pages on /ws/webtoon.
+type wsDone struct {
+	SentPages    int `json:"sentPages"`
+	SkippedPages int `json:"skippedPages"`
+}
+
+// wsError is the JSON control message sent instead of wsDone when the
+// strip cannot be built.
+type wsError struct {
+	Error string `json:"error"`
+}
+
+// handleWebSocket streams the pages of a strip over a WebSocket so clients
+// can render them one by one while the rest are still being decoded: a
+// JSON text message with the number of selected pages, one binary message
+// per page holding the processed page as PNG, in reading order, then a JSON
+// text message with the number of pages sent and skipped, or with the error
+// that stopped the strip. The connection is closed after the last message.
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filename, filePath, ok := s.resolveArchivePath(w, r)
+	if !ok {
+		return
+	}
+
+	opts, err := parseStripOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts.MaxInputBytes = s.maxInputBytes
+	// Pages are sent one at a time and never composed into a strip.
+	opts.MaxOutputPixels = -1
+
+	_, encodeOpts, err := parseOutputFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	release, ok := s.acquireJobSlot(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	server := websocket.Server{
+		Handshake: s.checkWebSocketOrigin,
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+
+			ctx, cancel := context.WithCancel(r.Context())
+			defer cancel()
+			if s.requestTimeout > 0 {
+				ctx, cancel = context.WithTimeout(ctx, s.requestTimeout)
+				defer cancel()
+			}
+
+			stream := &pageStream{ws: ws, opts: opts, pageOpts: pageOptions(opts), encodeOpts: encodeOpts, decoded: make(map[int]image.Image)}
+			// Decoding only starts once the page count is sent, so it
+			// always precedes the pages.
+			opts.OnDecodeStart = func(total int) {
+				if err := websocket.JSON.Send(ws, wsStart{PageCount: total}); err != nil {
+					slog.ErrorContext(r.Context(), "Error sending page count", "file", filename, "error", err)
+					stream.err = err
+					cancel()
+				}
+			}
+			opts.OnPageDecoded = func(index, _ int, page image.Image) {
+				if err := stream.add(index, page); err != nil {
+					slog.ErrorContext(r.Context(), "Error sending page", "file", filename, "page", index, "error", err)
+					cancel()
+				}
+			}
+
+			_, result, err := cbz.OpenStrip(ctx, filePath, opts)
+			if stream.err != nil {
+				return
+			}
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Error creating webtoon strip", "file", filename, "error", err)
+				websocket.JSON.Send(ws, wsError{Error: err.Error()})
+				return
+			}
+
+			done := wsDone{SentPages: stream.sent, SkippedPages: result.FailedDecodes + result.SkippedBlankPages}
+			if err := websocket.JSON.Send(ws, done); err != nil {
+				slog.ErrorContext(r.Context(), "Error sending done message", "file", filename, "error", err)
+				return
+			}
+
+			slog.InfoContext(r.Context(), "Streamed pages over WebSocket",
+				"file", filename,
+				"pages", stream.sent,
+				"duration_ms", time.Since(start).Milliseconds(),
+			)
+		},
+	}
+	server.ServeHTTP(w, r)
+}
+
+// pageStream sends pages over a WebSocket in reading order as they are
+// decoded, holding back pages decoded ahead of an earlier one.
+type pageStream struct {
+	ws         *websocket.Conn
+	opts       cbz.StripOptions
+	pageOpts   cbz.StripOptions
+	encodeOpts cbz.EncodeOptions
+
+	decoded map[int]image.Image
+	next    int
+	sent    int
+	buf     bytes.Buffer
+	err     error
+}
+
+// add records the page at index, nil if it failed to decode, and sends
+// every page it completes the reading order up to. After the first error
+// pages are dropped.
+func (p *pageStream) add(index int, page image.Image) error {
+	if p.err != nil {
+		return nil
+	}
+	p.decoded[index] = page
+
+	for {
+		page, ok := p.decoded[p.next]
+		if !ok {
+			return nil
+		}
+		delete(p.decoded, p.next)
+		p.next++
+		if page == nil {
+			continue
+		}
+
+		for _, part := range cbz.PreparePage(page, p.opts) {
+			if p.err = p.send(part); p.err != nil {
+				return p.err
+			}
+		}
+	}
+}
+
+// send encodes page as PNG with the strip's filters and sends it.
+func (p *pageStream) send(page image.Image) error {
+	p.buf.Reset()
+	img := cbz.CompositeStrip([]image.Image{page}, p.pageOpts)
+	err := cbz.EncodeWithOptions(&p.buf, img, "png", p.encodeOpts)
+	cbz.ReleaseStrip(img)
+	if err != nil {
+		return err
+	}
+	if err := websocket.Message.Send(p.ws, p.buf.Bytes()); err != nil {
+		return err
+	}
+	p.sent++
+	return nil
+}
+
+// checkWebSocketOrigin rejects WebSocket handshakes from browser pages on
+// other origins, unless -cors-origin allows them. Clients that send no Origin
+// header, which browsers always do, are allowed.
+func (s *Server) checkWebSocketOrigin(config *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" || s.corsOrigin == "*" || origin == s.corsOrigin {
+		return nil
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return err
+	}
+	if u.Host != r.Host {
+		return websocket.ErrBadWebSocketOrigin
+	}
+	return nil
+}
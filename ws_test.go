@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+// dialWebSocket opens /ws/webtoon on srv with the given query.
+func dialWebSocket(t *testing.T, srv *httptest.Server, query string) *websocket.Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws/webtoon?" + query
+	ws, err := websocket.Dial(url, "", srv.URL)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+func TestWebSocketStreamsPages(t *testing.T) {
+	s, _ := newTestServer(t)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	ws := dialWebSocket(t, srv, "file=ch1.cbz")
+	var start wsStart
+	if err := websocket.JSON.Receive(ws, &start); err != nil {
+		t.Fatalf("start message: %v", err)
+	}
+	if start.PageCount != 2 {
+		t.Errorf("start = %+v, want 2 pages", start)
+	}
+
+	for i := range 2 {
+		var page []byte
+		if err := websocket.Message.Receive(ws, &page); err != nil {
+			t.Fatalf("page %d: %v", i, err)
+		}
+		img, err := png.Decode(bytes.NewReader(page))
+		if err != nil {
+			t.Fatalf("page %d is not a PNG: %v", i, err)
+		}
+		if got := img.Bounds().Dx(); got != 100 {
+			t.Errorf("page %d width = %d, want 100", i, got)
+		}
+	}
+
+	var done wsDone
+	if err := websocket.JSON.Receive(ws, &done); err != nil {
+		t.Fatalf("done message: %v", err)
+	}
+	if done.SentPages != 2 || done.SkippedPages != 0 {
+		t.Errorf("done = %+v, want 2 pages and none skipped", done)
+	}
+}
+
+func TestWebSocketReportsErrorsAfterUpgrade(t *testing.T) {
+	s, _ := newTestServer(t)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	ws := dialWebSocket(t, srv, "file=ch1.cbz&skip=0,1")
+	var msg wsError
+	if err := websocket.JSON.Receive(ws, &msg); err != nil {
+		t.Fatalf("error message: %v", err)
+	}
+	if msg.Error == "" {
+		t.Error("no error reported for a strip skipping every page")
+	}
+}